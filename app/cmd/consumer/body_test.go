@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/linkedin/goavro/v2"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testdata = "../../pkg/decoder/testdata/"
+
+const orderJSON = `{"id":"order-1","amount":42,"customer":{"name":"acme","tier":"GOLD"},"tags":["a","b"],
+	"lines":[{"sku":"x-1","quantity":1},{"sku":"x-2","quantity":3}],"price":9.5,"paid":true,"billing":null}`
+
+// keyPaths - keys of orderJSON, every format has to give the same key for each path
+var keyPaths = []struct {
+	path string
+	key  string
+}{
+	{"id", "order-1"}, {"$.id", "order-1"}, {"$['id']", "order-1"}, {"amount", "42"},
+	{"customer.name", "acme"}, {"customer.tier", "GOLD"}, {"tags[1]", "b"}, {"tags[-1]", "b"},
+	{"lines[1].sku", "x-2"}, {"lines[0].quantity", "1"}, {"price", "9.5"}, {"paid", "true"},
+	{"billing", ""}, {"billing.name", ""}, {"customer", ""}, {"tags", ""}, {"missing", ""},
+}
+
+func TestBodyKeyFormats(t *testing.T) {
+	registry, err := decoder.New(decoder.ContentTypeJSON, testdata+"order.pb", "shop.Order", testdata+"order.avsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := map[string]any{
+		"id": "order-1", "amount": 42, "customer": map[string]any{"name": "acme", "tier": "GOLD"}, "tags": []any{"a", "b"},
+		"lines": []any{map[string]any{"sku": "x-1", "quantity": 1}, map[string]any{"sku": "x-2", "quantity": 3}},
+		"price": 9.5, "paid": true, "billing": nil,
+	}
+	msgPackBody, err := msgpack.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cborBody, err := cbor.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "json without content type", body: []byte(orderJSON)},
+		{name: "json as text", contentType: "text/plain", body: []byte(orderJSON)},
+		{name: "json as octet stream", contentType: "application/octet-stream", body: []byte(orderJSON)},
+		{name: "msgpack", contentType: decoder.ContentTypeMsgPack, body: msgPackBody},
+		{name: "cbor", contentType: decoder.ContentTypeCBOR, body: cborBody},
+		{name: "avro", contentType: decoder.ContentTypeAvro, body: avroOrderBody(t)},
+		{name: "protobuf", contentType: decoder.ContentTypeProtobuf, body: protoOrderBody(t)},
+	}
+
+	for _, tt := range keyPaths {
+		fKey, err := fetchKeyFn("body", tt.path, "", registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fKey(&amqp.Delivery{ContentType: decoder.ContentTypeJSON, Body: []byte(orderJSON)}); got != tt.key {
+			t.Fatalf("key of JSON body at %s = %q, want %q", tt.path, got, tt.key)
+		}
+		for _, format := range formats {
+			t.Run(format.name+"/"+tt.path, func(t *testing.T) {
+				if got := fKey(&amqp.Delivery{ContentType: format.contentType, Body: format.body}); got != tt.key {
+					t.Errorf("key = %q, want %q as of JSON body", got, tt.key)
+				}
+			})
+		}
+	}
+}
+
+func avroOrderBody(t *testing.T) []byte {
+	t.Helper()
+	schema, err := os.ReadFile(testdata + "order.avsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := codec.BinaryFromNative(nil, map[string]any{
+		"id":       "order-1",
+		"amount":   int64(42),
+		"customer": goavro.Union("crm.Customer", map[string]any{"name": "acme", "tier": goavro.Union("crm.Tier", "GOLD")}),
+		"tags":     []any{"a", "b"},
+		"lines": []any{
+			goavro.Union("shop.Line", map[string]any{"sku": goavro.Union("string", "x-1"), "quantity": int32(1)}),
+			goavro.Union("shop.Line", map[string]any{"sku": goavro.Union("string", "x-2"), "quantity": int32(3)}),
+		},
+		"price":      goavro.Union("double", 9.5),
+		"paid":       true,
+		"billing":    goavro.Union("null", nil),
+		"created":    goavro.Union("null", nil),
+		"total":      goavro.Union("null", nil),
+		"attributes": map[string]any{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func protoOrderBody(t *testing.T) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(testdata + "order.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(raw, &set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := files.FindDescriptorByName("shop.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+	// null of JSON document is a missing message in protobuf
+	doc := `{"id":"order-1","amount":"42","customer":{"name":"acme","tier":"GOLD"},"tags":["a","b"],
+		"lines":[{"sku":"x-1","quantity":1},{"sku":"x-2","quantity":3}],"price":9.5,"paid":true}`
+	if err = protojson.Unmarshal([]byte(doc), msg); err != nil {
+		t.Fatal(err)
+	}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
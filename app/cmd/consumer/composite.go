@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Policies applied when a part of composite key is missing
+const (
+	PartMissingFail  = "fail"
+	PartMissingEmpty = "empty"
+)
+
+// part - literal text or reference to a value in the message
+type part struct {
+	literal string
+	source  string
+	name    string
+	path    []segment
+}
+
+// parseTemplate - parses composite key template, e.g. {h:tenant}/{b:account.id}. References are {h:name}
+// for header, {b:path} for JSON body path and {p:name} for AMQP property, e.g. {p:message-id}.
+// Everything outside of braces is copied as it is.
+func parseTemplate(tmpl string) ([]part, error) {
+	parts := make([]part, 0)
+	for rest := tmpl; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			parts = append(parts, part{literal: rest})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, part{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid key template %q: unclosed brace", tmpl)
+		}
+		ref := rest[start+1 : start+end]
+		source, name, ok := strings.Cut(ref, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid key template %q: reference %q has to be in source:name format", tmpl, ref)
+		}
+		p := part{source: source, name: name}
+		switch source {
+		case "h":
+		case "p":
+			if _, ok := properties[name]; !ok {
+				return nil, fmt.Errorf("invalid key template %q: unknown property %q", tmpl, name)
+			}
+		case "b":
+			path, err := parsePath(name)
+			if err != nil {
+				return nil, err
+			}
+			p.path = path
+		default:
+			return nil, fmt.Errorf("invalid key template %q: unknown source %q", tmpl, source)
+		}
+		parts = append(parts, p)
+		rest = rest[start+end+1:]
+	}
+	for i := range parts {
+		if parts[i].source != "" {
+			return parts, nil
+		}
+	}
+	// constant key would send every message to the same partition
+	return nil, fmt.Errorf("invalid key template %q: no reference", tmpl)
+}
+
+// templateKeyFn - builds key from template parts, body is decoded once per message.
+// With fail policy key is missing when any part is missing, with empty policy missing parts are left out
+// and key is missing only when all of them are.
+func templateKeyFn(tmpl, policy string, decoders decoder.Registry) (func(msg *amqp.Delivery) string, error) {
+	switch policy {
+	case PartMissingFail, PartMissingEmpty:
+	default:
+		return nil, fmt.Errorf("unknown key part missing policy: %s", policy)
+	}
+	parts, err := parseTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return func(msg *amqp.Delivery) string {
+		var (
+			doc     any
+			decoded bool
+			found   bool
+			b       strings.Builder
+		)
+		for i := range parts {
+			var v string
+			switch parts[i].source {
+			case "":
+				b.WriteString(parts[i].literal)
+				continue
+			case "h":
+				v = headerKey(msg, parts[i].name)
+			case "p":
+				v = properties[parts[i].name](msg)
+			case "b":
+				if !decoded {
+					if data, err := keyBody(msg); err == nil {
+						if doc, err = decoders.Decode(msg.ContentType, data); err != nil {
+							unreadableBodies.Add("decode", 1)
+						}
+					}
+					decoded = true
+				}
+				v = bodyKey(doc, parts[i].path)
+			}
+			if v == "" && policy == PartMissingFail {
+				return ""
+			}
+			found = found || v != ""
+			b.WriteString(v)
+		}
+		if !found {
+			return ""
+		}
+		return b.String()
+	}, nil
+}
@@ -2,11 +2,10 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"time"
 
-	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
 	rabbit2 "github.com/dnsx2k/partymq/app/pkg/rabbit"
 	"github.com/dnsx2k/partymq/app/pkg/sender"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -22,12 +21,16 @@ type consumerCtx struct {
 	queue            string
 	keySource        string
 	keyName          string
+	partMissing      string
+	decoders         decoder.Registry
+	transforms       []string
 	start            chan struct{}
 	stop             chan struct{}
 }
 
-// New - creation function
-func New(amqpOrch rabbit2.AmqpOrchestrator, sender sender.Sender, logger *zap.Logger, queue, keySource, keyName string) *consumerCtx {
+// New - creation function, partMissing policy applies to template keys, decoders decode body by its content type,
+// transforms are applied on extracted key in order
+func New(amqpOrch rabbit2.AmqpOrchestrator, sender sender.Sender, logger *zap.Logger, queue, keySource, keyName, partMissing string, decoders decoder.Registry, transforms []string) *consumerCtx {
 	cctx := &consumerCtx{
 		amqpOrchestrator: amqpOrch,
 		sender:           sender,
@@ -35,6 +38,9 @@ func New(amqpOrch rabbit2.AmqpOrchestrator, sender sender.Sender, logger *zap.Lo
 		queue:            queue,
 		keySource:        keySource,
 		keyName:          keyName,
+		partMissing:      partMissing,
+		decoders:         decoders,
+		transforms:       transforms,
 		start:            make(chan struct{}, 1),
 		stop:             make(chan struct{}, 1),
 	}
@@ -53,7 +59,14 @@ func (cs *consumerCtx) CheckState() {
 }
 
 func (cs *consumerCtx) Consume(ctx context.Context, exit chan struct{}) error {
-	fKey := fetchKeyFn(cs.keySource, cs.keyName)
+	fKey, err := fetchKeyFn(cs.keySource, cs.keyName, cs.partMissing, cs.decoders)
+	if err != nil {
+		return err
+	}
+	tKey, err := parseTransforms(cs.transforms)
+	if err != nil {
+		return err
+	}
 	var consumerChan *amqp.Channel
 	for {
 		select {
@@ -76,22 +89,17 @@ func (cs *consumerCtx) Consume(ctx context.Context, exit chan struct{}) error {
 				for {
 					select {
 					case msg := <-msgs:
-						key := fKey(&msg)
+						key := tKey(fKey(&msg))
 						// hack: amqp lib will spam with nil messages
 						if msg.Body == nil {
 							time.Sleep(1 * time.Second)
 							continue
 						}
-						if err := cs.sender.Send(ctx, msg.Body, msg.Headers, key); err != nil {
-							// consumer should disconnect while there is no client, but with 10sec gap it is still possible
-							if errors.Is(err, partition.ErrClientNotFound) {
-								_ = msg.Reject(true)
-								continue
-							} else {
-								cs.logger.Error("error occurred while processing message", zap.String("priority", "low"), zap.Error(err))
-								_ = msg.Nack(false, true)
-								continue
-							}
+						// message of a group without clients is parked by sender, it's not requeued here
+						if err := cs.sender.Send(ctx, &msg, key); err != nil {
+							cs.logger.Error("error occurred while processing message", zap.String("priority", "low"), zap.Error(err))
+							_ = msg.Nack(false, true)
+							continue
 						}
 						_ = msg.Ack(false)
 					case <-exit:
@@ -107,33 +115,67 @@ func (cs *consumerCtx) Consume(ctx context.Context, exit chan struct{}) error {
 	}
 }
 
-func fetchKeyFn(source, key string) func(msg *amqp.Delivery) string {
+// fetchKeyFn - returns function extracting partition key from message, empty key means it's missing.
+// Body is decompressed according to its content encoding, body which can't be decoded has no key.
+func fetchKeyFn(source, key, partMissing string, decoders decoder.Registry) (func(msg *amqp.Delivery) string, error) {
 	switch source {
 	case "header":
 		return func(msg *amqp.Delivery) string {
-			h, ok := msg.Headers[key]
-			if !ok {
-				return ""
-			}
-			keyStr, ok := h.(string)
-			if !ok {
-				return ""
-			}
-			return keyStr
-		}
+			return headerKey(msg, key)
+		}, nil
 	case "body":
+		path, err := parsePath(key)
+		if err != nil {
+			return nil, err
+		}
 		return func(msg *amqp.Delivery) string {
-			m := map[string]any{}
-			if err := json.Unmarshal(msg.Body, &m); err != nil {
+			data, err := keyBody(msg)
+			if err != nil {
 				return ""
 			}
-			keyStr, ok := m[key].(string)
-			if !ok {
+			// JSON is scanned for the key only, large bodies are not decoded as a whole
+			if decoders.JSON(msg.ContentType) {
+				keyStr, _ := scanKey(data, path)
+				return keyStr
+			}
+			doc, err := decoders.Decode(msg.ContentType, data)
+			if err != nil {
+				unreadableBodies.Add("decode", 1)
 				return ""
 			}
-			return keyStr
-		}
+			return bodyKey(doc, path)
+		}, nil
+	case "template":
+		return templateKeyFn(key, partMissing, decoders)
+	case "expression":
+		return expressionKeyFn(key, decoders)
+	case "wasm":
+		return pluginKeyFn(key)
+	}
+	if property, ok := properties[source]; ok {
+		return property, nil
+	}
+
+	return nil, fmt.Errorf("unknown key source: %s", source)
+}
+
+func headerKey(msg *amqp.Delivery, name string) string {
+	h, ok := msg.Headers[name]
+	if !ok {
+		return ""
+	}
+	keyStr, ok := h.(string)
+	if !ok {
+		return ""
 	}
+	return keyStr
+}
 
-	return nil
+func bodyKey(doc any, path []segment) string {
+	v, ok := lookup(doc, path)
+	if !ok {
+		return ""
+	}
+	keyStr, _ := canonical(v)
+	return keyStr
 }
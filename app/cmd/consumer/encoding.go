@@ -0,0 +1,92 @@
+package consumer
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"expvar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// maxDecompressed - body which decompresses to more bytes is not read, protects against compression bombs
+const maxDecompressed = 64 << 20
+
+// snappyMagic - beginning of snappy framing format stream, other snappy bodies are single blocks
+const snappyMagic = "\xff\x06\x00\x00sNaPpY"
+
+// unreadableBodies - number of bodies which couldn't be decompressed or decoded for key lookup, by reason,
+// published at /debug/vars
+var unreadableBodies = expvar.NewMap("partymq_unreadable_bodies")
+
+// zstdDecoder - DecodeAll is safe for concurrent use, the decoder is shared
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressed))
+
+// keyBody - returns message body for key extraction, decompressed according to content encoding.
+// Encodings listed in content encoding are undone in reverse order, forwarded message keeps its original body.
+func keyBody(msg *amqp.Delivery) ([]byte, error) {
+	data := msg.Body
+	if msg.ContentEncoding == "" {
+		return data, nil
+	}
+	encodings := strings.Split(msg.ContentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		if data, err = decompress(strings.ToLower(strings.TrimSpace(encodings[i])), data); err != nil {
+			unreadableBodies.Add("decompress", 1)
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return data, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return readLimited(r)
+	case "deflate":
+		// deflate should be zlib wrapped, but raw deflate streams are common as well
+		if r, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			return readLimited(r)
+		}
+		return readLimited(flate.NewReader(bytes.NewReader(data)))
+	case "zstd":
+		return zstdDecoder.DecodeAll(data, nil)
+	case "snappy", "x-snappy-framed":
+		if bytes.HasPrefix(data, []byte(snappyMagic)) {
+			return readLimited(snappy.NewReader(bytes.NewReader(data)))
+		}
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDecompressed {
+			return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressed)
+		}
+		return snappy.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressed+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressed {
+		return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressed)
+	}
+	return data, nil
+}
@@ -0,0 +1,142 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// expressionKeyFn - evaluates CEL expression over message headers, properties and decoded body,
+// e.g. headers.tenant + ":" + string(body.order.id). Expression is compiled once, it has to return
+// a string, number or bool. Body is decoded only when expression reads it, evaluation error
+// (e.g. missing header or field) means the key is missing.
+func expressionKeyFn(expr string, decoders decoder.Registry) (func(msg *amqp.Delivery) string, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("headers", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("properties", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("body", cel.DynType),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("invalid key expression %q: %w", expr, iss.Err())
+	}
+	switch ast.OutputType() {
+	case cel.StringType, cel.IntType, cel.UintType, cel.DoubleType, cel.BoolType, cel.DynType:
+	default:
+		return nil, fmt.Errorf("invalid key expression %q: returns %s, has to return string, number or bool", expr, ast.OutputType())
+	}
+	prg, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key expression %q: %w", expr, err)
+	}
+
+	return func(msg *amqp.Delivery) string {
+		out, _, err := prg.Eval(map[string]any{
+			"headers": func() any { return celValue(msg.Headers) },
+			"properties": func() any {
+				props := make(map[string]string, len(properties))
+				for name, property := range properties {
+					props[name] = property(msg)
+				}
+				return props
+			},
+			"body": func() ref.Val {
+				data, err := keyBody(msg)
+				if err != nil {
+					return types.NewErr("body can't be decompressed: %v", err)
+				}
+				doc, err := decoders.Decode(msg.ContentType, data)
+				if err != nil {
+					unreadableBodies.Add("decode", 1)
+					return types.NewErr("body can't be decoded: %v", err)
+				}
+				return types.DefaultTypeAdapter.NativeToValue(celValue(doc))
+			},
+		})
+		if err != nil {
+			return ""
+		}
+		return expressionKey(out)
+	}, nil
+}
+
+// expressionKey - converts expression result to key the same way body values are converted
+func expressionKey(out ref.Val) string {
+	var v any
+	switch t := out.Value().(type) {
+	case string, bool, float64:
+		v = t
+	case int64:
+		v = json.Number(strconv.FormatInt(t, 10))
+	case uint64:
+		v = json.Number(strconv.FormatUint(t, 10))
+	}
+	keyStr, _ := canonical(v)
+	return keyStr
+}
+
+// celValue - converts header table and decoded body to values CEL understands. Numbers become int, uint
+// or double, integers which don't fit 64 bits are kept as strings, so no digits are lost.
+func celValue(v any) any {
+	switch t := v.(type) {
+	case amqp.Table:
+		return celValue(map[string]any(t))
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[k] = celValue(e)
+		}
+		return m
+	case []any:
+		l := make([]any, len(t))
+		for i := range t {
+			l[i] = celValue(t[i])
+		}
+		return l
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return u
+		}
+		if !strings.ContainsAny(string(t), ".eE") {
+			return string(t)
+		}
+		f, _ := strconv.ParseFloat(string(t), 64)
+		return f
+	case int8:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case int:
+		return int64(t)
+	case uint8:
+		return uint64(t)
+	case uint16:
+		return uint64(t)
+	case uint32:
+		return uint64(t)
+	case float32:
+		return float64(t)
+	case amqp.Decimal:
+		f, _ := strconv.ParseFloat(fmt.Sprintf("%de-%d", t.Value, t.Scale), 64)
+		return f
+	case time.Time, string, bool, int64, uint64, float64, []byte, nil:
+		return t
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,127 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment - single step of a key path, either object field or array index
+type segment struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// parsePath - parses dot path like payload.order.customerId or items[0].id, JSONPath root "$" is optional
+// and fields containing dots can be quoted in brackets, e.g. $['order.id']. Negative index counts from the end.
+func parsePath(expr string) ([]segment, error) {
+	expr = strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	if expr == "" {
+		return nil, fmt.Errorf("empty key path")
+	}
+	path := make([]segment, 0)
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			if i == 0 || i == len(expr)-1 || expr[i+1] == '.' {
+				return nil, fmt.Errorf("invalid key path %q: empty field", expr)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid key path %q: unclosed bracket", expr)
+			}
+			inner := expr[i+1 : i+end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, segment{field: inner[1 : len(inner)-1]})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid key path %q: index %q is not integer", expr, inner)
+				}
+				path = append(path, segment{index: n, isIndex: true})
+			}
+			i += end + 1
+		default:
+			end := strings.IndexAny(expr[i:], ".[")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			path = append(path, segment{field: expr[i : i+end]})
+			i += end
+		}
+	}
+	return path, nil
+}
+
+// lookup - walks decoded JSON document, reports false when any step of the path is missing
+func lookup(doc any, path []segment) (any, bool) {
+	for _, s := range path {
+		switch v := doc.(type) {
+		case map[string]any:
+			if s.isIndex {
+				return nil, false
+			}
+			next, ok := v[s.field]
+			if !ok {
+				return nil, false
+			}
+			doc = next
+		case []any:
+			if !s.isIndex {
+				return nil, false
+			}
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// canonical - converts scalar value to key. Integers keep all their digits, other numbers are written
+// without exponent and trailing zeros (1.50 and 15e-1 become "1.5", 1e3 and 1.0 become "1000" and "1",
+// 1e21 and 1e-7 become "1000000000000000000000" and "0.0000001").
+// Null, objects and arrays are not keys.
+func canonical(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case bool:
+		return strconv.FormatBool(t), true
+	case json.Number:
+		// JSON integers have no leading zeros, so the literal is already canonical
+		if !strings.ContainsAny(string(t), ".eE") {
+			if t == "-0" {
+				return "0", true
+			}
+			return string(t), true
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return "", false
+		}
+		return canonicalFloat(f), true
+	case float64:
+		return canonicalFloat(t), true
+	}
+	return "", false
+}
+
+// canonicalFloat - shortest decimal which parses back to f, negative zero is the same key as zero
+func canonicalFloat(f float64) string {
+	if f == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
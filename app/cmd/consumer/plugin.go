@@ -0,0 +1,175 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Functions plugin module has to export besides its memory
+const (
+	// pluginAlloc - alloc(size i32) i32, reserves buffer for input in module memory
+	pluginAlloc = "alloc"
+	// pluginKey - partition_key(meta_ptr, meta_len, body_ptr, body_len i32) i64, returns key location as ptr<<32 | len,
+	// zero length means the key is missing. Meta is JSON object with headers and properties of the message.
+	pluginKey = "partition_key"
+	// pluginFree - free(ptr i32), optional, releases buffers of input and key after each message
+	pluginFree = "free"
+
+	// pluginTimeout - module which doesn't return key in time is restarted, message has no key
+	pluginTimeout = time.Second
+)
+
+// pluginMeta - message metadata passed to plugin
+type pluginMeta struct {
+	Headers    amqp.Table        `json:"headers"`
+	Properties map[string]string `json:"properties"`
+}
+
+// plugin - WASM module extracting partition key, module instance is not safe for concurrent use
+type plugin struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	module   api.Module
+	mutex    sync.Mutex
+}
+
+// pluginKeyFn - loads WASM module which replaces built-in key extraction. WASI is available, so modules
+// built by TinyGo, Rust or other WASI toolchains work, stdout and stderr of the module go to the app's ones.
+func pluginKeyFn(path string) (func(msg *amqp.Delivery) string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	p := &plugin{runtime: wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))}
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return nil, err
+	}
+	if p.compiled, err = p.runtime.CompileModule(ctx, raw); err != nil {
+		return nil, fmt.Errorf("invalid key plugin %s: %w", path, err)
+	}
+	for _, name := range []string{pluginAlloc, pluginKey} {
+		if _, ok := p.compiled.ExportedFunctions()[name]; !ok {
+			return nil, fmt.Errorf("invalid key plugin %s: function %s is not exported", path, name)
+		}
+	}
+	if err = p.instantiate(ctx); err != nil {
+		return nil, fmt.Errorf("invalid key plugin %s: %w", path, err)
+	}
+
+	return func(msg *amqp.Delivery) string {
+		key, _ := p.key(msg)
+		return key
+	}, nil
+}
+
+// instantiate - starts new module instance, reactor modules are initialized by _initialize
+func (p *plugin) instantiate(ctx context.Context) error {
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr)
+	module, err := p.runtime.InstantiateModule(ctx, p.compiled, config)
+	if err != nil {
+		return err
+	}
+	p.module = module
+	return nil
+}
+
+func (p *plugin) key(msg *amqp.Delivery) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// module closed by timeout or trap is replaced, state of the previous one can't be trusted
+	if p.module.IsClosed() {
+		if err := p.instantiate(context.Background()); err != nil {
+			return "", err
+		}
+	}
+	props := make(map[string]string, len(properties))
+	for name, property := range properties {
+		props[name] = property(msg)
+	}
+	meta, err := json.Marshal(pluginMeta{Headers: msg.Headers, Properties: props})
+	if err != nil {
+		return "", err
+	}
+	data, err := keyBody(msg)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	keyStr, err := p.call(ctx, meta, data)
+	if err != nil {
+		// wazero keeps module open after trap, its memory could be corrupted, so the next message gets a fresh instance
+		_ = p.module.Close(context.Background())
+		return "", err
+	}
+
+	return keyStr, nil
+}
+
+// call - passes input to module and reads the key, buffers are released even if key can't be read
+func (p *plugin) call(ctx context.Context, meta, data []byte) (string, error) {
+	metaPtr, err := p.write(ctx, meta)
+	if err != nil {
+		return "", err
+	}
+	bodyPtr, err := p.write(ctx, data)
+	if err != nil {
+		p.free(ctx, metaPtr)
+		return "", err
+	}
+	res, err := p.module.ExportedFunction(pluginKey).Call(ctx, uint64(metaPtr), uint64(len(meta)), uint64(bodyPtr), uint64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	keyPtr, keyLen := uint32(res[0]>>32), uint32(res[0])
+	key, ok := p.module.Memory().Read(keyPtr, keyLen)
+	if !ok {
+		p.free(ctx, metaPtr, bodyPtr, keyPtr)
+		return "", errors.New("key plugin returned key out of memory range")
+	}
+	keyStr := string(key)
+	p.free(ctx, metaPtr, bodyPtr, keyPtr)
+
+	return keyStr, nil
+}
+
+// write - copies data to buffer allocated by module
+func (p *plugin) write(ctx context.Context, data []byte) (uint32, error) {
+	res, err := p.module.ExportedFunction(pluginAlloc).Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(res[0])
+	if !p.module.Memory().Write(ptr, data) {
+		return 0, errors.New("key plugin allocated buffer out of memory range")
+	}
+	return ptr, nil
+}
+
+func (p *plugin) free(ctx context.Context, ptrs ...uint32) {
+	free := p.module.ExportedFunction(pluginFree)
+	if free == nil {
+		return
+	}
+	for _, ptr := range ptrs {
+		if ptr != 0 {
+			_, _ = free.Call(ctx, uint64(ptr))
+		}
+	}
+}
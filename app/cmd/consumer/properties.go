@@ -0,0 +1,13 @@
+package consumer
+
+import amqp "github.com/rabbitmq/amqp091-go"
+
+// properties - AMQP message properties which can hold partition key, routing-key is the one message was published with
+var properties = map[string]func(msg *amqp.Delivery) string{
+	"message-id":     func(msg *amqp.Delivery) string { return msg.MessageId },
+	"correlation-id": func(msg *amqp.Delivery) string { return msg.CorrelationId },
+	"user-id":        func(msg *amqp.Delivery) string { return msg.UserId },
+	"app-id":         func(msg *amqp.Delivery) string { return msg.AppId },
+	"type":           func(msg *amqp.Delivery) string { return msg.Type },
+	"routing-key":    func(msg *amqp.Delivery) string { return msg.RoutingKey },
+}
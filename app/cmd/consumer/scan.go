@@ -0,0 +1,253 @@
+package consumer
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// scanner - reads JSON document in place, values off the key path are skipped without being decoded
+type scanner struct {
+	data []byte
+	pos  int
+}
+
+// scanKey - finds value at path in JSON document and converts it to key the same way as bodyKey,
+// without decoding the document. Scanning stops as soon as the value is found, so the rest of the document
+// is not validated, and the first of duplicated fields wins. Only the returned key is allocated.
+func scanKey(data []byte, path []segment) (string, bool) {
+	s := scanner{data: data}
+	for i := range path {
+		var ok bool
+		if path[i].isIndex {
+			ok = s.element(path[i].index)
+		} else {
+			ok = s.field(path[i].field)
+		}
+		if !ok {
+			return "", false
+		}
+	}
+	return s.scalar()
+}
+
+// field - moves to the value of object field, reports false when current value is not an object or has no such field
+func (s *scanner) field(name string) bool {
+	if !s.consume('{') {
+		return false
+	}
+	if s.consume('}') {
+		return false
+	}
+	for {
+		key, escaped, ok := s.str()
+		if !ok || !s.consume(':') {
+			return false
+		}
+		if matches(key, escaped, name) {
+			s.space()
+			return true
+		}
+		if !s.skip() {
+			return false
+		}
+		if !s.consume(',') {
+			return false
+		}
+	}
+}
+
+// element - moves to array element, negative index counts from the end, so the array is counted first
+func (s *scanner) element(index int) bool {
+	s.space()
+	if s.pos >= len(s.data) || s.data[s.pos] != '[' {
+		return false
+	}
+	if index < 0 {
+		n, ok := s.length()
+		if !ok {
+			return false
+		}
+		index += n
+		if index < 0 {
+			return false
+		}
+	}
+	s.pos++
+	if s.consume(']') {
+		return false
+	}
+	for i := 0; i < index; i++ {
+		if !s.skip() || !s.consume(',') {
+			return false
+		}
+	}
+	s.space()
+	return true
+}
+
+// length - number of elements of array starting at current position, position is not moved
+func (s *scanner) length() (int, bool) {
+	start := s.pos
+	defer func() { s.pos = start }()
+	s.pos++
+	if s.consume(']') {
+		return 0, true
+	}
+	n := 0
+	for {
+		if !s.skip() {
+			return 0, false
+		}
+		n++
+		if s.consume(']') {
+			return n, true
+		}
+		if !s.consume(',') {
+			return 0, false
+		}
+	}
+}
+
+// scalar - converts value at current position to key, null, objects and arrays are not keys
+func (s *scanner) scalar() (string, bool) {
+	s.space()
+	if s.pos >= len(s.data) {
+		return "", false
+	}
+	switch c := s.data[s.pos]; {
+	case c == '"':
+		raw, escaped, ok := s.str()
+		if !ok {
+			return "", false
+		}
+		// invalid UTF-8 is decoded as well, so every bad byte becomes U+FFFD the same way as in decoded body
+		if !escaped && utf8.Valid(raw) {
+			return string(raw), true
+		}
+		var v string
+		if err := json.Unmarshal(s.data[s.pos-len(raw)-2:s.pos], &v); err != nil {
+			return "", false
+		}
+		return v, true
+	case c == 't' || c == 'f':
+		switch string(s.literal()) {
+		case "true":
+			return "true", true
+		case "false":
+			return "false", true
+		}
+		return "", false
+	case c == '-' || (c >= '0' && c <= '9'):
+		lit := s.literal()
+		if !json.Valid(lit) {
+			return "", false
+		}
+		return canonical(json.Number(lit))
+	}
+	return "", false
+}
+
+// str - reads string at current position, returns its raw content and whether it contains escapes
+func (s *scanner) str() ([]byte, bool, bool) {
+	s.space()
+	if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+		return nil, false, false
+	}
+	start := s.pos + 1
+	escaped := false
+	for i := start; i < len(s.data); i++ {
+		switch s.data[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			s.pos = i + 1
+			return s.data[start:i], escaped, true
+		}
+	}
+	return nil, false, false
+}
+
+// literal - reads number, true, false or null at current position
+func (s *scanner) literal() []byte {
+	start := s.pos
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return s.data[start:s.pos]
+		}
+		s.pos++
+	}
+	return s.data[start:s.pos]
+}
+
+// skip - moves past value at current position, nested values are skipped by tracking depth
+func (s *scanner) skip() bool {
+	s.space()
+	if s.pos >= len(s.data) {
+		return false
+	}
+	switch s.data[s.pos] {
+	case '"':
+		_, _, ok := s.str()
+		return ok
+	case '{', '[':
+	default:
+		return len(s.literal()) > 0
+	}
+	depth := 0
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '"':
+			if _, _, ok := s.str(); !ok {
+				return false
+			}
+			continue
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return true
+			}
+		}
+		s.pos++
+	}
+	return false
+}
+
+// consume - moves past next non-space byte when it is c
+func (s *scanner) consume(c byte) bool {
+	s.space()
+	if s.pos < len(s.data) && s.data[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *scanner) space() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// matches - compares raw field name with path field, escaped names and names with invalid UTF-8 are decoded first
+func matches(raw []byte, escaped bool, name string) bool {
+	if !escaped && utf8.Valid(raw) {
+		return string(raw) == name
+	}
+	var v string
+	quoted := make([]byte, 0, len(raw)+2)
+	quoted = append(append(append(quoted, '"'), raw...), '"')
+	if err := json.Unmarshal(quoted, &v); err != nil {
+		return false
+	}
+	return v == name
+}
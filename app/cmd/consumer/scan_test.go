@@ -0,0 +1,165 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
+)
+
+// largeBody - ~200KB order with the key at the beginning and the end of the document
+func largeBody() []byte {
+	var b strings.Builder
+	b.WriteString(`{"order":{"id":"ord-1842","customer":{"id":123456789}},"items":[`)
+	for i := 0; i < 1500; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"sku":"SKU-%06d","name":"item \"%d\" with a longer description","qty":%d,"price":%d.99,"tags":["a","b","c"],"attrs":{"color":"red","size":null}}`, i, i, i%7, i)
+	}
+	b.WriteString(`],"meta":{"tenant":"acme"}}`)
+	return []byte(b.String())
+}
+
+func benchmarkBodyKey(b *testing.B, expr string, extract func(body []byte, path []segment) string) {
+	body := largeBody()
+	path, err := parsePath(expr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if extract(body, path) == "" {
+		b.Fatal("key not found")
+	}
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extract(body, path)
+	}
+}
+
+var jsonDecoders, _ = decoder.New(decoder.ContentTypeJSON, "", "", "")
+
+func decodeKey(body []byte, path []segment) string {
+	doc, err := jsonDecoders.Decode(decoder.ContentTypeJSON, body)
+	if err != nil {
+		return ""
+	}
+	return bodyKey(doc, path)
+}
+
+func scanBodyKey(body []byte, path []segment) string {
+	keyStr, _ := scanKey(body, path)
+	return keyStr
+}
+
+func BenchmarkBodyKeyDecodeFirst(b *testing.B) {
+	benchmarkBodyKey(b, "order.customer.id", decodeKey)
+}
+
+func BenchmarkBodyKeyScanFirst(b *testing.B) {
+	benchmarkBodyKey(b, "order.customer.id", scanBodyKey)
+}
+
+func BenchmarkBodyKeyDecodeLast(b *testing.B) {
+	benchmarkBodyKey(b, "meta.tenant", decodeKey)
+}
+
+func BenchmarkBodyKeyScanLast(b *testing.B) {
+	benchmarkBodyKey(b, "meta.tenant", scanBodyKey)
+}
+
+func BenchmarkBodyKeyDecodeNegativeIndex(b *testing.B) {
+	benchmarkBodyKey(b, "items[-1].sku", decodeKey)
+}
+
+func BenchmarkBodyKeyScanNegativeIndex(b *testing.B) {
+	benchmarkBodyKey(b, "items[-1].sku", scanBodyKey)
+}
+
+func TestScanKey(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		path string
+		want string
+		// partial - document is broken after the key, scanner stops at the key while decoder rejects the whole body
+		partial bool
+	}{
+		{name: "string", body: `{"a":"x"}`, path: "a", want: "x"},
+		{name: "nested", body: `{"a":{"b":{"c":"x"}}}`, path: "a.b.c", want: "x"},
+		{name: "field after skipped values", body: `{"s":"}]\"","o":{"a":[1,{"b":2}]},"n":null,"a":"x"}`, path: "a", want: "x"},
+		{name: "whitespace", body: " {\n\t\"a\" :\r\n \"x\" } ", path: "a", want: "x"},
+		{name: "first duplicate wins", body: `{"a":"x","a":"y"}`, path: "a", want: "x", partial: true},
+		{name: "escaped value", body: `{"a":"q\"\\\/\b\f\n\r\t"}`, path: "a", want: "q\"\\/\b\f\n\r\t"},
+		{name: "unicode escape", body: `{"a":"\u00e9\ud83d\ude00"}`, path: "a", want: "é😀"},
+		{name: "escaped field name", body: `{"\u0061":"x"}`, path: "a", want: "x"},
+		{name: "quoted field with dot", body: `{"order.id":"x"}`, path: "$['order.id']", want: "x"},
+		{name: "invalid UTF-8 value", body: "{\"a\":\"\xff\xfe\"}", path: "a", want: "\ufffd\ufffd"},
+		{name: "invalid UTF-8 field name", body: "{\"\xff\":\"x\"}", path: "['\ufffd']", want: "x"},
+		{name: "index", body: `{"a":[{"b":"x"},{"b":"y"}]}`, path: "a[1].b", want: "y"},
+		{name: "negative index", body: `{"a":[{"b":"x"},[1,2],{"b":"y"}]}`, path: "a[-1].b", want: "y"},
+		{name: "nested index", body: `{"a":[[1,2],[3,[4,5]]]}`, path: "a[1][1][0]", want: "4"},
+		{name: "nested negative index", body: `{"a":[[1,2],[3,[4,5]]]}`, path: "a[-1][-1][-2]", want: "4"},
+		{name: "index out of range", body: `{"a":[1,2]}`, path: "a[2]"},
+		{name: "negative index out of range", body: `{"a":[1,2]}`, path: "a[-3]"},
+		{name: "index of empty array", body: `{"a":[]}`, path: "a[0]"},
+		{name: "index of object", body: `{"a":{"0":"x"}}`, path: "a[0]"},
+		{name: "field of array", body: `{"a":["x"]}`, path: "a.b"},
+		{name: "integer", body: `{"a":123456789012345678901234567890}`, path: "a", want: "123456789012345678901234567890"},
+		{name: "negative zero", body: `{"a":-0}`, path: "a", want: "0"},
+		{name: "float", body: `{"a":1.50}`, path: "a", want: "1.5"},
+		{name: "integral float", body: `{"a":2.0e3}`, path: "a", want: "2000"},
+		{name: "large float", body: `{"a":1e21}`, path: "a", want: "1000000000000000000000"},
+		{name: "small float", body: `{"a":1E-7}`, path: "a", want: "0.0000001"},
+		{name: "negative zero float", body: `{"a":-0.0}`, path: "a", want: "0"},
+		{name: "negative float", body: `{"a":-0.25}`, path: "a", want: "-0.25"},
+		{name: "true", body: `{"a":true}`, path: "a", want: "true"},
+		{name: "false", body: `{"a":false}`, path: "a", want: "false"},
+		{name: "null", body: `{"a":null}`, path: "a"},
+		{name: "object", body: `{"a":{"b":1}}`, path: "a"},
+		{name: "array", body: `{"a":[1]}`, path: "a"},
+		{name: "missing field", body: `{"b":"x"}`, path: "a"},
+		{name: "empty object", body: `{}`, path: "a"},
+		{name: "not an object", body: `"a"`, path: "a"},
+		{name: "empty body", body: ``, path: "a"},
+		{name: "truncated before key", body: `{"b":[1,2`, path: "a"},
+		{name: "truncated string", body: `{"a":"x`, path: "a"},
+		{name: "truncated escape", body: `{"a":"x\`, path: "a"},
+		{name: "truncated number", body: `{"a":1.`, path: "a"},
+		{name: "truncated literal", body: `{"a":tru`, path: "a"},
+		{name: "truncated after key", body: `{"a":"x","b":[`, path: "a", want: "x", partial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := parsePath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := scanKey([]byte(tt.body), path)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("scanKey() = %q, %v, want %q", got, ok, tt.want)
+			}
+			if tt.partial {
+				return
+			}
+			if decoded := decodeKey([]byte(tt.body), path); got != decoded {
+				t.Errorf("scanKey() = %q, decoded body gives %q", got, decoded)
+			}
+		})
+	}
+}
+
+func TestScanKeyLargeBody(t *testing.T) {
+	body := largeBody()
+	for _, expr := range []string{"order.id", "order.customer.id", "items[0].sku", "items[-1].price", "items[700].attrs.color", "items[3].attrs.size", "meta.tenant"} {
+		path, err := parsePath(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := scanBodyKey(body, path), decodeKey(body, path); got != want {
+			t.Errorf("%s: scanKey() = %q, decoded body gives %q", expr, got, want)
+		}
+	}
+}
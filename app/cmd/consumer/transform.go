@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// transform - changes extracted key, empty result means the key is missing
+type transform func(key string) string
+
+// parseTransforms - builds pipeline applying transforms in order, each of them is one of:
+// lowercase, trim, regex:<expression>, strip-prefix:<prefix>, bucket:<n>. Missing key is not transformed.
+func parseTransforms(specs []string) (transform, error) {
+	steps := make([]transform, 0, len(specs))
+	for _, spec := range specs {
+		step, err := parseTransform(spec)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return func(key string) string {
+		for _, step := range steps {
+			if key == "" {
+				return ""
+			}
+			key = step(key)
+		}
+		return key
+	}, nil
+}
+
+func parseTransform(spec string) (transform, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "lowercase":
+		return strings.ToLower, nil
+	case "trim":
+		return strings.TrimSpace, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key transform %q: %w", spec, err)
+		}
+		return regexTransform(re), nil
+	case "strip-prefix":
+		return func(key string) string {
+			return strings.TrimPrefix(key, arg)
+		}, nil
+	case "bucket":
+		n, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid key transform %q: number of buckets has to be positive integer", spec)
+		}
+		return bucketTransform(uint32(n)), nil
+	}
+	return nil, fmt.Errorf("unknown key transform: %s", spec)
+}
+
+// regexTransform - key becomes the first capture group or the whole match when expression has no group,
+// key which doesn't match is missing
+func regexTransform(re *regexp.Regexp) transform {
+	group := 0
+	if re.NumSubexp() > 0 {
+		group = 1
+	}
+	return func(key string) string {
+		m := re.FindStringSubmatch(key)
+		if m == nil {
+			return ""
+		}
+		return m[group]
+	}
+}
+
+// bucketTransform - replaces key with its bucket number, keys of the same bucket share partition and ordering
+func bucketTransform(n uint32) transform {
+	return func(key string) string {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return strconv.FormatUint(uint64(h.Sum32()%n), 10)
+	}
+}
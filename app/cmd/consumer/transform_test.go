@@ -0,0 +1,52 @@
+package consumer
+
+import "testing"
+
+func TestParseTransforms(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		key   string
+		want  string
+	}{
+		{name: "no transforms", key: "Order-1", want: "Order-1"},
+		{name: "lowercase", specs: []string{"lowercase"}, key: "Order-1", want: "order-1"},
+		{name: "trim", specs: []string{"trim"}, key: " \torder-1\n", want: "order-1"},
+		{name: "strip prefix", specs: []string{"strip-prefix:tenant:"}, key: "tenant:acme", want: "acme"},
+		{name: "strip missing prefix", specs: []string{"strip-prefix:tenant:"}, key: "acme", want: "acme"},
+		{name: "applied in order", specs: []string{"trim", "lowercase", "strip-prefix:tenant-"}, key: " TENANT-Acme ", want: "acme"},
+		{name: "order matters", specs: []string{"strip-prefix:tenant-", "lowercase"}, key: "TENANT-Acme", want: "tenant-acme"},
+		{name: "regex with group", specs: []string{`regex:^([^/]+)/`}, key: "acme/orders/1", want: "acme"},
+		{name: "regex with several groups", specs: []string{`regex:^(\w+)-(\d+)$`}, key: "order-42", want: "order"},
+		{name: "regex without group", specs: []string{`regex:\d+`}, key: "order-42-7", want: "42"},
+		{name: "regex without match", specs: []string{`regex:\d+`}, key: "order", want: ""},
+		{name: "regex with empty group", specs: []string{`regex:^(\d*)-`}, key: "-order", want: ""},
+		{name: "empty result stops pipeline", specs: []string{"strip-prefix:order", "bucket:16"}, key: "order", want: ""},
+		{name: "missing key is not transformed", specs: []string{"bucket:16"}, key: "", want: ""},
+		{name: "bucket", specs: []string{"bucket:16"}, key: "order-1", want: "13"},
+		{name: "bucket of another key", specs: []string{"bucket:16"}, key: "order-2", want: "4"},
+		{name: "bucket after transforms", specs: []string{"lowercase", `regex:^(\w+)/`, "bucket:1000"}, key: "ACME/orders/1", want: "615"},
+		{name: "single bucket", specs: []string{"bucket:1"}, key: "tenant-42", want: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tKey, err := parseTransforms(tt.specs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tKey(tt.key); got != tt.want {
+				t.Errorf("transformed key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTransformsInvalid(t *testing.T) {
+	for _, spec := range []string{"bucket", "bucket:", "bucket:0", "bucket:-1", "bucket:1.5", "bucket:abc", "bucket:4294967296", "regex:(", "upper"} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := parseTransforms([]string{"lowercase", spec}); err == nil {
+				t.Errorf("transform %q accepted", spec)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"expvar"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/dnsx2k/partymq/app/pkg/handoff"
 	"github.com/dnsx2k/partymq/app/pkg/heartbeat"
 	"github.com/dnsx2k/partymq/app/pkg/helpers"
+	"github.com/dnsx2k/partymq/app/pkg/hotkey"
 	"github.com/dnsx2k/partymq/app/pkg/partition"
 	"github.com/dnsx2k/partymq/app/pkg/rabbit"
 	"github.com/gin-gonic/gin"
@@ -14,14 +20,21 @@ import (
 type HandlerCtx struct {
 	cache     partition.Cache
 	heartbeat heartbeat.HeartBeater
+	handoff   handoff.Handoff
+	hotKeys   hotkey.Tracker
 	logger    *zap.Logger
+	// rulesPersisted - affinity rules survive restart, otherwise rule changes are answered with a warning
+	rulesPersisted bool
 }
 
-func New(cache partition.Cache, heartbeat heartbeat.HeartBeater, logger *zap.Logger) *HandlerCtx {
+func New(cache partition.Cache, heartbeat heartbeat.HeartBeater, handoff handoff.Handoff, hotKeys hotkey.Tracker, logger *zap.Logger, rulesPersisted bool) *HandlerCtx {
 	return &HandlerCtx{
-		cache:     cache,
-		heartbeat: heartbeat,
-		logger:    logger,
+		cache:          cache,
+		heartbeat:      heartbeat,
+		handoff:        handoff,
+		hotKeys:        hotKeys,
+		logger:         logger,
+		rulesPersisted: rulesPersisted,
 	}
 }
 
@@ -30,25 +43,50 @@ func (c *HandlerCtx) RegisterRoute(router gin.IRouter) {
 	router.POST("clients/:hostname/ready", c.ready)
 	router.POST("clients/:hostname/unbind", c.unbind)
 	router.POST("clients/:hostname/heartbeat", c.beat)
+	router.POST("clients/:hostname/release", c.release)
+	router.POST("clients/:hostname/weight", c.weight)
+
+	router.GET("affinity/rules", c.rules)
+	router.POST("affinity/rules", c.putRule)
+	router.DELETE("affinity/rules/:id", c.deleteRule)
+
+	router.GET("hotkeys", c.hot)
+
+	router.GET("debug/cache", c.debugCache)
+	router.GET("debug/vars", gin.WrapH(expvar.Handler()))
 }
 
 func (c *HandlerCtx) bind(cGin *gin.Context) {
 	hostname := cGin.Param("hostname")
 
 	routingKey := helpers.BuildRoutingKey(hostname)
-	if err := c.cache.AddPending(hostname, routingKey); err != nil {
-		cGin.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	queue := cGin.Query("queue")
+	weight, err := strconv.Atoi(cGin.DefaultQuery("weight", "1"))
+	if err != nil || weight <= 0 {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": "weight has to be positive integer"})
 		return
 	}
-	c.logger.Info("client requested a binding", zap.String("hostname", hostname), zap.String("routing_key", routingKey))
+	labels, err := parseLabels(cGin.QueryArray("label"))
+	if err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p := partition.Partition{RoutingKey: routingKey, Queue: queue, Weight: weight, Labels: labels}
+	epoch := c.cache.AddPending(hostname, p)
+	c.logger.Info("client requested a binding", zap.String("hostname", hostname), zap.String("routing_key", routingKey), zap.String("queue", queue), zap.Int("weight", weight), zap.Any("labels", labels), zap.Uint64("epoch", epoch))
 
-	cGin.JSON(http.StatusOK, gin.H{"routingKey": routingKey, "exchange": rabbit.PartyMqExchange})
+	cGin.JSON(http.StatusOK, gin.H{"routingKey": routingKey, "exchange": rabbit.PartyMqExchange, "epoch": epoch})
 }
 
 func (c *HandlerCtx) ready(cGin *gin.Context) {
 	hostname := cGin.Param("hostname")
+	epoch, err := parseEpoch(cGin)
+	if err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := c.cache.AddReady(hostname); err != nil {
+	if err := c.cache.AddReady(hostname, epoch); err != nil {
 		cGin.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
 		c.logger.Error("binding unsuccessful", zap.String("hostname", hostname))
 		return
@@ -61,14 +99,146 @@ func (c *HandlerCtx) ready(cGin *gin.Context) {
 
 func (c *HandlerCtx) unbind(cGin *gin.Context) {
 	hostname := cGin.Param("hostname")
-	c.cache.Delete(hostname)
+	epoch, err := parseEpoch(cGin)
+	if err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err = c.cache.Delete(hostname, epoch); err != nil {
+		cGin.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 
 	cGin.Status(http.StatusOK)
 }
 
 func (c *HandlerCtx) beat(cGin *gin.Context) {
 	hostname := cGin.Param("hostname")
+	epoch, err := parseEpoch(cGin)
+	if err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err = c.cache.Verify(hostname, epoch); err != nil {
+		cGin.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	c.heartbeat.Beat(hostname)
 
 	cGin.Status(http.StatusOK)
 }
+
+// release - client confirms it processed all messages of keys which were moved away from it
+func (c *HandlerCtx) release(cGin *gin.Context) {
+	hostname := cGin.Param("hostname")
+	c.handoff.Release(hostname)
+	c.logger.Info("client released moved keys", zap.String("hostname", hostname))
+
+	cGin.Status(http.StatusOK)
+}
+
+// weight - changes declared capacity of ready client, keys are rebalanced proportionally
+func (c *HandlerCtx) weight(cGin *gin.Context) {
+	hostname := cGin.Param("hostname")
+	weight, err := strconv.Atoi(cGin.Query("weight"))
+	if err != nil || weight <= 0 {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": "weight has to be positive integer"})
+		return
+	}
+	if err = c.cache.SetWeight(hostname, weight); err != nil {
+		cGin.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.logger.Info("client weight changed", zap.String("hostname", hostname), zap.Int("weight", weight))
+
+	cGin.Status(http.StatusOK)
+}
+
+// debugCache - returns cache state with number of keys per client, responds with 500 when cache is inconsistent
+func (c *HandlerCtx) debugCache(cGin *gin.Context) {
+	snapshot := c.cache.Snapshot()
+	keys := make(map[string]int, len(snapshot.Clients))
+	for _, hostname := range snapshot.Keys {
+		keys[hostname]++
+	}
+	resp := gin.H{"clients": snapshot.Clients, "pending": snapshot.Pending, "keys": keys}
+	if err := c.cache.Check(); err != nil {
+		resp["violations"] = strings.Split(err.Error(), "\n")
+		cGin.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	cGin.JSON(http.StatusOK, resp)
+}
+
+func (c *HandlerCtx) rules(cGin *gin.Context) {
+	cGin.JSON(http.StatusOK, c.cache.Rules())
+}
+
+// putRule - adds or replaces affinity rule, keys matched by the rule are moved to its targets right away
+func (c *HandlerCtx) putRule(cGin *gin.Context) {
+	var rule partition.Rule
+	if err := cGin.ShouldBindJSON(&rule); err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.cache.PutRule(rule); err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.logger.Info("affinity rule saved", zap.String("id", rule.ID), zap.String("pattern", rule.Pattern))
+	if !c.rulesPersisted {
+		c.logger.Warn("affinity rule is not persisted, it is lost on restart", zap.String("id", rule.ID))
+		cGin.JSON(http.StatusOK, gin.H{"warning": "rule is not persisted, set state backend or rules path to keep it after restart"})
+		return
+	}
+
+	cGin.Status(http.StatusOK)
+}
+
+func (c *HandlerCtx) deleteRule(cGin *gin.Context) {
+	id := cGin.Param("id")
+	if err := c.cache.DeleteRule(id); err != nil {
+		cGin.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.logger.Info("affinity rule deleted", zap.String("id", id))
+
+	cGin.Status(http.StatusOK)
+}
+
+// hot - returns keys with the highest message rate in the last complete window
+func (c *HandlerCtx) hot(cGin *gin.Context) {
+	limit, err := strconv.Atoi(cGin.DefaultQuery("limit", "10"))
+	if err != nil {
+		cGin.JSON(http.StatusBadRequest, gin.H{"error": "limit has to be integer"})
+		return
+	}
+
+	cGin.JSON(http.StatusOK, c.hotKeys.Top(limit))
+}
+
+// parseEpoch - incarnation epoch returned from bind, clients which don't pass it are not checked
+func parseEpoch(cGin *gin.Context) (uint64, error) {
+	epoch, err := strconv.ParseUint(cGin.DefaultQuery("epoch", "0"), 10, 64)
+	if err != nil {
+		return 0, errors.New("epoch has to be non-negative integer")
+	}
+	return epoch, nil
+}
+
+// parseLabels - parses labels passed as key=value pairs
+func parseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, errors.New("label has to be in key=value format")
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
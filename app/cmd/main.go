@@ -15,10 +15,16 @@ import (
 	"github.com/dnsx2k/partymq/app/cmd/config"
 	"github.com/dnsx2k/partymq/app/cmd/consumer"
 	"github.com/dnsx2k/partymq/app/cmd/handlers"
+	"github.com/dnsx2k/partymq/app/pkg/decoder"
+	"github.com/dnsx2k/partymq/app/pkg/eviction"
+	"github.com/dnsx2k/partymq/app/pkg/handoff"
 	"github.com/dnsx2k/partymq/app/pkg/heartbeat"
+	"github.com/dnsx2k/partymq/app/pkg/hotkey"
+	"github.com/dnsx2k/partymq/app/pkg/load"
 	"github.com/dnsx2k/partymq/app/pkg/partition"
 	rabbit2 "github.com/dnsx2k/partymq/app/pkg/rabbit"
 	"github.com/dnsx2k/partymq/app/pkg/sender"
+	"github.com/dnsx2k/partymq/app/pkg/state"
 	"github.com/gin-gonic/gin"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.uber.org/zap"
@@ -36,7 +42,7 @@ func main() {
 		if errors.Is(err, conf.ErrHelpWanted) {
 			fmt.Println(help)
 		}
-		fmt.Printf("parsing config: %w", err)
+		fmt.Printf("parsing config: %v", err)
 		os.Exit(1)
 	}
 
@@ -54,12 +60,101 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	cache := partition.NewCache()
-	senderSrv, err := sender.New(cache, ch, logger)
+	// every client group gets its own strategy instance, name is validated upfront
+	if _, err := partition.NewStrategy(appCfg.PartitionConfig.Strategy, appCfg.PartitionConfig.VirtualNodes); err != nil {
+		log.Fatal(err.Error())
+	}
+	newStrategy := func() partition.AssignmentStrategy {
+		strategy, _ := partition.NewStrategy(appCfg.PartitionConfig.Strategy, appCfg.PartitionConfig.VirtualNodes)
+		return strategy
+	}
+	routes, err := sender.ParseRoutes(appCfg.GroupConfig.Routes, appCfg.GroupConfig.Label)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	quota := partition.Quota{
+		MaxKeys:   appCfg.QuotaConfig.MaxKeys,
+		MaxFactor: appCfg.QuotaConfig.MaxFactor,
+		Strict:    appCfg.QuotaConfig.Overflow != sender.OverflowAssign,
+	}
+	if err = declareOverflowQueues(amqpOrchestrator, appCfg.QuotaConfig.Overflow, appCfg.QuotaConfig.ParkTTL, appCfg.SourceQueue); err != nil {
+		log.Fatal(err.Error())
+	}
+	cache := partition.NewCache(newStrategy, appCfg.PartitionConfig.Slots, quota, appCfg.GroupConfig.Label)
+
+	clientTTL, err := time.ParseDuration(appCfg.HeartBeatConfig.ExpiresAfter)
+	if err != nil {
+		logger.Error("can not parse duration client TTL, default values will be set", zap.Error(err))
+	}
+	checkInterval, err := time.ParseDuration(appCfg.HeartBeatConfig.CheckInterval)
+	if err != nil {
+		logger.Error("can not parse duration check interval, default values will be set", zap.Error(err))
+	}
+	gracePeriod, err := time.ParseDuration(appCfg.HeartBeatConfig.GracePeriod)
+	if err != nil {
+		logger.Error("can not parse duration grace period, expired clients will be deleted right away", zap.Error(err))
+	}
+	heartBeat := heartbeat.New(cache, logger, clientTTL, checkInterval, gracePeriod)
+
+	// State has to be restored before consumer starts, otherwise keys would be assigned from scratch
+	rulesPersisted := true
+	switch {
+	case appCfg.StateConfig.Backend != state.BackendNone:
+		restoreState(cache, heartBeat, logger, appCfg.StateConfig.Backend, appCfg.StateConfig.Path, appCfg.StateConfig.SnapshotInterval)
+	case appCfg.StateConfig.RulesPath != "":
+		restoreRules(cache, logger, appCfg.StateConfig.RulesPath)
+	default:
+		rulesPersisted = false
+		logger.Warn("neither state backend nor rules path is set, affinity rules are lost on restart")
+	}
+
+	handoffInterval, err := time.ParseDuration(appCfg.HandoffConfig.CheckInterval)
+	if err != nil || handoffInterval <= 0 {
+		logger.Error("can not parse duration handoff check interval, default values will be set", zap.Error(err))
+		handoffInterval = 5 * time.Second
+	}
+	releaseTimeout, err := time.ParseDuration(appCfg.HandoffConfig.ReleaseTimeout)
+	if err != nil {
+		logger.Error("can not parse duration handoff release timeout, moved keys will wait for release", zap.Error(err))
+	}
+	keyHandoff := handoff.New(cache, amqpOrchestrator, logger, handoffInterval, releaseTimeout)
+	if appCfg.HandoffConfig.Enabled {
+		cache.Subscribe(keyHandoff.Listen)
+	}
+	// parking queues outlive restart, their messages are forwarded even if handoff was disabled since
+	if err = keyHandoff.Recover(); err != nil {
+		logger.Error("can not recover parked messages of previous run", zap.Error(err))
+	}
+
+	hotKeyWindow, err := time.ParseDuration(appCfg.HotKeyConfig.Window)
+	if err != nil {
+		logger.Error("can not parse duration hot key window, default values will be set", zap.Error(err))
+	}
+	hotKeys := hotkey.New(appCfg.HotKeyConfig.Capacity, hotKeyWindow)
+	if err = hotkey.StartIsolation(hotKeys, cache, logger, appCfg.HotKeyConfig.Policy, appCfg.HotKeyConfig.Label, appCfg.HotKeyConfig.Threshold, hotKeyWindow); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	meter := load.NewMeter()
+	senderSrv, err := sender.New(cache, keyHandoff, ch, logger, appCfg.QuotaConfig.Overflow, routes, hotKeys, meter, appCfg.KeyConfig.OnMissing, appCfg.KeyConfig.DefaultClient)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if appCfg.KeyConfig.OnMissing == sender.MissingDeadLetter {
+		if err = amqpOrchestrator.CreateQueue(rabbit2.DeadLetterQueue, nil); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	logger.Info("missing key policy", zap.String("policy", appCfg.KeyConfig.OnMissing), zap.String("default_client", appCfg.KeyConfig.DefaultClient))
+
+	decoders, err := decoder.New(appCfg.DecoderConfig.Default, appCfg.DecoderConfig.ProtoDescriptors, appCfg.DecoderConfig.ProtoMessage, appCfg.DecoderConfig.AvroSchema)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
 	// AMQP
 
-	partyConsumer := consumer.New(amqpOrchestrator, senderSrv, logger, appCfg.SourceQueue, appCfg.KeyConfig.Source, appCfg.KeyConfig.Key)
+	partyConsumer := consumer.New(amqpOrchestrator, senderSrv, logger, appCfg.SourceQueue, appCfg.KeyConfig.Source, appCfg.KeyConfig.Key, appCfg.KeyConfig.PartMissing, decoders, appCfg.KeyConfig.Transforms)
 	doneCh := make(chan struct{})
 	ctx := context.Background()
 	// TODO: handle error in different way
@@ -70,19 +165,29 @@ func main() {
 	}()
 	go partyConsumer.CheckState()
 
-	clientTTL, err := time.ParseDuration(appCfg.HeartBeatConfig.ExpiresAfter)
+	idleAfter, err := time.ParseDuration(appCfg.EvictionConfig.IdleAfter)
 	if err != nil {
-		logger.Error("can not parse duration client TTL, default values will be set", zap.Error(err))
+		logger.Error("can not parse duration key idle span, eviction will be disabled", zap.Error(err))
 	}
-	checkInterval, err := time.ParseDuration(appCfg.HeartBeatConfig.CheckInterval)
+	evictionInterval, err := time.ParseDuration(appCfg.EvictionConfig.CheckInterval)
 	if err != nil {
-		logger.Error("can not parse duration check interval, default values will be set", zap.Error(err))
+		logger.Error("can not parse duration eviction check interval, default values will be set", zap.Error(err))
+	}
+	if idleAfter > 0 {
+		eviction.Start(cache, amqpOrchestrator, logger, idleAfter, evictionInterval)
+	}
+
+	if appCfg.LoadConfig.Enabled {
+		loadInterval, err := time.ParseDuration(appCfg.LoadConfig.CheckInterval)
+		if err != nil {
+			logger.Error("can not parse duration load check interval, default values will be set", zap.Error(err))
+		}
+		load.Start(cache, amqpOrchestrator, meter, hotKeys, logger, appCfg.LoadConfig.Threshold, appCfg.LoadConfig.MaxMoves, loadInterval)
 	}
-	heartBeat := heartbeat.New(cache, logger, clientTTL, checkInterval)
 
 	// HTTP
 	router := gin.Default()
-	handler := handlers.New(cache, heartBeat, logger)
+	handler := handlers.New(cache, heartBeat, keyHandoff, hotKeys, logger, rulesPersisted)
 	handler.RegisterRoute(router)
 
 	// HC
@@ -101,9 +206,79 @@ func main() {
 	shutdown(doneCh, logger, 10*time.Second)
 }
 
+// declareOverflowQueues - parked messages are dead-lettered back to the source queue after TTL. Overflow queue
+// is declared regardless of policy, messages of groups without clients are parked in it as well
+func declareOverflowQueues(amqpOrch rabbit2.AmqpOrchestrator, policy, parkTTL, sourceQueue string) error {
+	ttl, err := time.ParseDuration(parkTTL)
+	if err != nil {
+		return err
+	}
+	if err = amqpOrch.CreateQueue(rabbit2.OverflowQueue, amqp.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": sourceQueue,
+	}); err != nil {
+		return err
+	}
+	if policy == sender.OverflowReject {
+		return amqpOrch.CreateQueue(rabbit2.DeadLetterQueue, nil)
+	}
+	return nil
+}
+
+func restoreState(cache partition.Cache, heartBeat heartbeat.HeartBeater, logger *zap.Logger, backend, path, interval string) {
+	store, err := state.New(backend, path)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	st, err := store.Load()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	cache.Restore(st.Partition)
+	hostnames := make([]string, 0, len(st.Partition.Clients))
+	for hostname := range st.Partition.Clients {
+		hostnames = append(hostnames, hostname)
+	}
+	heartBeat.Restore(st.Expiry, hostnames)
+	logger.Info("state restored", zap.String("backend", backend), zap.Int("clients", len(st.Partition.Clients)), zap.Int("keys", len(st.Partition.Keys)))
+
+	snapshotInterval, err := time.ParseDuration(interval)
+	if err != nil || snapshotInterval <= 0 {
+		logger.Error("can not parse duration snapshot interval, default values will be set", zap.Error(err))
+		snapshotInterval = time.Minute
+	}
+	journal := state.NewJournal(store, logger)
+	// snapshot is queued under the cache lock, so no event can get between them. Expiries are taken
+	// beforehand, heartbeat calls the cache under its own lock
+	capture := func() {
+		expiry := heartBeat.Expiries()
+		cache.Capture(func(s partition.Snapshot) {
+			journal.Save(state.State{Partition: s, Expiry: expiry})
+		})
+	}
+	// snapshot right away, so mutation log starts from the restored state
+	cache.Subscribe(journal.Record)
+	capture()
+	journal.StartSnapshots(snapshotInterval, capture)
+}
+
+// restoreRules - affinity rules are kept in their own file when no state backend persists them
+func restoreRules(cache partition.Cache, logger *zap.Logger, path string) {
+	rules, err := state.OpenRuleFile(path, logger)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	s := partition.NewSnapshot()
+	s.Rules = rules.Rules()
+	cache.Restore(s)
+	cache.Subscribe(rules.Record)
+	logger.Info("affinity rules restored", zap.String("path", path), zap.Int("rules", len(s.Rules)))
+}
+
 // TODO: Improve
 func shutdown(doneCh chan struct{}, logger *zap.Logger, timeout time.Duration) {
-	interruptChan := make(chan os.Signal)
+	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	<-interruptChan
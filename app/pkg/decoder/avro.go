@@ -0,0 +1,195 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroCtx - avro datums are decoded with a single writer schema, its named types are kept by full name
+// so union values can be unwrapped
+type avroCtx struct {
+	codec *goavro.Codec
+	root  any
+	names map[string]any
+}
+
+// newAvro - loads schema file, body has to be a single binary encoded datum without container header.
+// Union values are unwrapped, so paths don't include union branch names.
+func newAvro(path string) (Decoder, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema %s: %w", path, err)
+	}
+	a := &avroCtx{codec: codec, names: make(map[string]any)}
+	if err = json.Unmarshal(raw, &a.root); err != nil {
+		// goavro accepts bare primitive names, e.g. string, which are not valid JSON
+		a.root = strings.TrimSpace(string(raw))
+	}
+	a.collect(a.root, "")
+
+	return a.decode, nil
+}
+
+func (a *avroCtx) decode(body []byte, _ map[string]string) (any, error) {
+	native, _, err := a.codec.NativeFromBinary(body)
+	if err != nil {
+		return nil, err
+	}
+	return a.unwrap(a.root, "", native), nil
+}
+
+// collect - registers named types of schema by their full names
+func (a *avroCtx) collect(schema any, ns string) {
+	switch s := schema.(type) {
+	case []any:
+		for i := range s {
+			a.collect(s[i], ns)
+		}
+	case map[string]any:
+		switch s["type"] {
+		case "record", "error", "enum", "fixed":
+			full := fullName(s, ns)
+			a.names[full] = s
+			ns = namespaceOf(full)
+		}
+		if fields, ok := s["fields"].([]any); ok {
+			for i := range fields {
+				if f, ok := fields[i].(map[string]any); ok {
+					a.collect(f["type"], ns)
+				}
+			}
+		}
+		a.collect(s["items"], ns)
+		a.collect(s["values"], ns)
+		if _, ok := s["type"].(string); !ok {
+			a.collect(s["type"], ns)
+		}
+	}
+}
+
+// unwrap - walks decoded datum along its schema, replaces union values by the value of their branch
+func (a *avroCtx) unwrap(schema any, ns string, v any) any {
+	switch s := schema.(type) {
+	case string:
+		if def, ok := a.names[resolve(s, ns)]; ok {
+			return a.unwrap(def, namespaceOf(resolve(s, ns)), v)
+		}
+	case []any:
+		// null branch is decoded as nil, others as map with branch name as the only key
+		m, ok := v.(map[string]any)
+		if !ok || len(m) != 1 {
+			break
+		}
+		for k, e := range m {
+			for i := range s {
+				if a.branchName(s[i], ns) == k {
+					return a.unwrap(s[i], ns, e)
+				}
+			}
+			v = e
+		}
+	case map[string]any:
+		switch s["type"] {
+		case "record", "error":
+			m, ok := v.(map[string]any)
+			if !ok {
+				break
+			}
+			ns = namespaceOf(fullName(s, ns))
+			fields, _ := s["fields"].([]any)
+			for i := range fields {
+				f, _ := fields[i].(map[string]any)
+				name, _ := f["name"].(string)
+				if e, ok := m[name]; ok {
+					m[name] = a.unwrap(f["type"], ns, e)
+				}
+			}
+			return m
+		case "array":
+			if items, ok := v.([]any); ok {
+				for i := range items {
+					items[i] = a.unwrap(s["items"], ns, items[i])
+				}
+				return items
+			}
+		case "map":
+			if values, ok := v.(map[string]any); ok {
+				for k := range values {
+					values[k] = a.unwrap(s["values"], ns, values[k])
+				}
+				return values
+			}
+		default:
+			// type may be nested schema, e.g. {"type": {"type": "array", "items": "string"}}
+			if _, ok := s["type"].(string); !ok {
+				return a.unwrap(s["type"], ns, v)
+			}
+		}
+	}
+	return normalizeAvro(v)
+}
+
+// branchName - name goavro uses for union branch, full name for named types and type.logicalType for logical ones
+func (a *avroCtx) branchName(schema any, ns string) string {
+	switch s := schema.(type) {
+	case string:
+		if _, ok := a.names[resolve(s, ns)]; ok {
+			return resolve(s, ns)
+		}
+		return s
+	case map[string]any:
+		t, _ := s["type"].(string)
+		switch t {
+		case "record", "error", "enum", "fixed":
+			return fullName(s, ns)
+		}
+		if logical, ok := s["logicalType"].(string); ok {
+			return t + "." + logical
+		}
+		return t
+	}
+	return ""
+}
+
+// normalizeAvro - decimals are decoded as rationals, other values are shared with the rest of decoders
+func normalizeAvro(v any) any {
+	if r, ok := v.(*big.Rat); ok {
+		if r.IsInt() {
+			return json.Number(r.Num().String())
+		}
+		f, _ := r.Float64()
+		return f
+	}
+	return normalize(v)
+}
+
+func fullName(schema map[string]any, ns string) string {
+	name, _ := schema["name"].(string)
+	if namespace, ok := schema["namespace"].(string); ok && !strings.Contains(name, ".") {
+		ns = namespace
+	}
+	return resolve(name, ns)
+}
+
+func resolve(name, ns string) string {
+	if ns == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return ns + "." + name
+}
+
+func namespaceOf(full string) string {
+	if i := strings.LastIndexByte(full, '.'); i >= 0 {
+		return full[:i]
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package decoder
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func newTestAvro(t *testing.T) (*avroCtx, *goavro.Codec) {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/order.avsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := goavro.NewCodec(string(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &avroCtx{codec: codec, names: make(map[string]any)}
+	if err = json.Unmarshal(raw, &a.root); err != nil {
+		t.Fatal(err)
+	}
+	a.collect(a.root, "")
+	return a, codec
+}
+
+// avroOrder - native goavro form of order, union values are wrapped in maps keyed by branch name
+func avroOrder() map[string]any {
+	return map[string]any{
+		"id":       "order-1",
+		"amount":   int64(42),
+		"customer": goavro.Union("crm.Customer", map[string]any{"name": "acme", "tier": goavro.Union("crm.Tier", "GOLD")}),
+		"tags":     []any{"a", "b"},
+		"lines": []any{
+			goavro.Union("shop.Line", map[string]any{"sku": goavro.Union("string", "x-1"), "quantity": int32(1)}),
+			goavro.Union("shop.Line", map[string]any{"sku": goavro.Union("string", "x-2"), "quantity": int32(3)}),
+		},
+		"price":      goavro.Union("double", 9.5),
+		"paid":       true,
+		"billing":    goavro.Union("null", nil),
+		"created":    goavro.Union("null", nil),
+		"total":      goavro.Union("null", nil),
+		"attributes": map[string]any{},
+	}
+}
+
+func TestAvroDecode(t *testing.T) {
+	a, codec := newTestAvro(t)
+	created := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		modify func(native map[string]any)
+		want   func(doc map[string]any)
+	}{
+		{name: "same document as json"},
+		{
+			name: "null branches",
+			modify: func(native map[string]any) {
+				native["customer"] = goavro.Union("null", nil)
+				native["lines"] = []any{goavro.Union("null", nil)}
+			},
+			want: func(doc map[string]any) {
+				doc["customer"] = nil
+				doc["lines"] = []any{nil}
+			},
+		},
+		{
+			// crm.Customer is referenced by full name outside of its namespace
+			name: "named type referenced from other namespace",
+			modify: func(native map[string]any) {
+				native["billing"] = goavro.Union("crm.Customer", map[string]any{"name": "billing", "tier": goavro.Union("null", nil)})
+			},
+			want: func(doc map[string]any) {
+				doc["billing"] = map[string]any{"name": "billing", "tier": nil}
+			},
+		},
+		{
+			name: "logical types in unions",
+			modify: func(native map[string]any) {
+				native["created"] = goavro.Union("long.timestamp-millis", created)
+				native["total"] = goavro.Union("bytes.decimal", big.NewRat(1234, 100))
+			},
+			want: func(doc map[string]any) {
+				doc["created"] = "2024-05-01T10:30:00Z"
+				doc["total"] = 12.34
+			},
+		},
+		{
+			name: "integral decimal",
+			modify: func(native map[string]any) {
+				native["total"] = goavro.Union("bytes.decimal", big.NewRat(1200, 100))
+			},
+			want: func(doc map[string]any) {
+				doc["total"] = json.Number("12")
+			},
+		},
+		{
+			name: "map of unions",
+			modify: func(native map[string]any) {
+				native["attributes"] = map[string]any{"channel": goavro.Union("string", "web"), "retries": goavro.Union("long", int64(2)), "note": goavro.Union("null", nil)}
+			},
+			want: func(doc map[string]any) {
+				doc["attributes"] = map[string]any{"channel": "web", "retries": json.Number("2"), "note": nil}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			native := avroOrder()
+			want := map[string]any{"billing": nil, "created": nil, "total": nil, "attributes": map[string]any{}}
+			for k, v := range wantOrder {
+				want[k] = v
+			}
+			if tt.modify != nil {
+				tt.modify(native)
+				tt.want(want)
+			}
+			body, err := codec.BinaryFromNative(nil, native)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := a.decode(body, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, want) {
+				t.Errorf("decoded %#v, want %#v", doc, want)
+			}
+		})
+	}
+}
+
+func TestAvroNames(t *testing.T) {
+	a, _ := newTestAvro(t)
+	for _, name := range []string{"shop.Order", "crm.Customer", "crm.Tier", "shop.Line"} {
+		if _, ok := a.names[name]; !ok {
+			t.Errorf("named type %s is not registered, registered: %v", name, reflect.ValueOf(a.names).MapKeys())
+		}
+	}
+	if len(a.names) != 4 {
+		t.Errorf("registered %d named types, want 4", len(a.names))
+	}
+
+	tests := []struct {
+		name   string
+		schema any
+		ns     string
+		want   string
+	}{
+		{name: "primitive", schema: "string", ns: "shop", want: "string"},
+		{name: "named type in namespace", schema: "Line", ns: "shop", want: "shop.Line"},
+		{name: "full name", schema: "crm.Customer", ns: "shop", want: "crm.Customer"},
+		{name: "unknown name is kept", schema: "Missing", ns: "shop", want: "Missing"},
+		{name: "record with namespace", schema: map[string]any{"type": "record", "name": "Customer", "namespace": "crm"}, ns: "shop", want: "crm.Customer"},
+		{name: "record in enclosing namespace", schema: map[string]any{"type": "record", "name": "Line"}, ns: "shop", want: "shop.Line"},
+		{name: "dotted name ignores namespace", schema: map[string]any{"type": "enum", "name": "crm.Tier", "namespace": "other"}, ns: "shop", want: "crm.Tier"},
+		{name: "logical type", schema: map[string]any{"type": "long", "logicalType": "timestamp-millis"}, want: "long.timestamp-millis"},
+		{name: "complex type", schema: map[string]any{"type": "array", "items": "string"}, want: "array"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.branchName(tt.schema, tt.ns); got != tt.want {
+				t.Errorf("branchName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Content types of built-in decoders
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeAvro     = "avro/binary"
+)
+
+// Decoder - decodes message body into document made of map[string]any, []any, string, bool, json.Number,
+// float64 and nil, params are parameters of message content type
+type Decoder func(body []byte, params map[string]string) (any, error)
+
+// Registry - picks decoder by message content type, so body fields can be looked up regardless of wire format
+type Registry interface {
+	Decode(contentType string, body []byte) (any, error)
+	// JSON - reports whether body of content type is JSON, such body can be scanned instead of decoded
+	JSON(contentType string) bool
+}
+
+type registryCtx struct {
+	decoders map[string]Decoder
+	fallback string
+}
+
+// New - creation function, fallback content type is assumed for messages without one. JSON, MessagePack and CBOR
+// are always registered, Protobuf needs descriptor set file and Avro schema file, empty path leaves them out.
+// protoMessage is full name of body message type, content type parameter proto overrides it.
+func New(fallback, protoDescriptors, protoMessage, avroSchema string) (Registry, error) {
+	r := &registryCtx{
+		decoders: map[string]Decoder{
+			ContentTypeJSON:           decodeJSON,
+			ContentTypeMsgPack:        decodeMsgPack,
+			"application/x-msgpack":   decodeMsgPack,
+			"application/vnd.msgpack": decodeMsgPack,
+			ContentTypeCBOR:           decodeCBOR,
+		},
+		fallback: fallback,
+	}
+	if protoDescriptors != "" {
+		d, err := newProtobuf(protoDescriptors, protoMessage)
+		if err != nil {
+			return nil, err
+		}
+		r.register(d, ContentTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf")
+	}
+	if avroSchema != "" {
+		d, err := newAvro(avroSchema)
+		if err != nil {
+			return nil, err
+		}
+		r.register(d, ContentTypeAvro, "application/avro", "application/vnd.apache.avro+binary")
+	}
+	if _, ok := r.lookup(fallback); !ok {
+		return nil, fmt.Errorf("no decoder for default content type: %s", fallback)
+	}
+	return r, nil
+}
+
+// register - adds decoder for passed content types, replaces previous ones
+func (r *registryCtx) register(d Decoder, contentTypes ...string) {
+	for _, ct := range contentTypes {
+		r.decoders[strings.ToLower(ct)] = d
+	}
+}
+
+// Decode - content types without decoder, e.g. text/plain or application/octet-stream, are decoded
+// as the default content type, producers often don't set precise one
+func (r *registryCtx) Decode(contentType string, body []byte) (any, error) {
+	d, params := r.resolve(contentType)
+	return d(body, params)
+}
+
+func (r *registryCtx) JSON(contentType string) bool {
+	// the most common case doesn't need parsing
+	if contentType == ContentTypeJSON {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = r.fallback
+	} else if _, ok := r.lookup(mediaType); !ok {
+		mediaType = r.fallback
+	}
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == ContentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// resolve - picks decoder of content type, default content type is used when there is none
+func (r *registryCtx) resolve(contentType string) (Decoder, map[string]string) {
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		if d, ok := r.lookup(mediaType); ok {
+			return d, params
+		}
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.fallback)
+	d, _ := r.lookup(mediaType)
+	return d, params
+}
+
+// lookup - finds decoder by media type or by its structured syntax suffix, e.g. application/vnd.order+json
+func (r *registryCtx) lookup(mediaType string) (Decoder, bool) {
+	mediaType = strings.ToLower(mediaType)
+	if d, ok := r.decoders[mediaType]; ok {
+		return d, true
+	}
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		d, ok := r.decoders["application/"+mediaType[i+1:]]
+		return d, ok
+	}
+	return nil, false
+}
+
+// decodeJSON - numbers are kept as json.Number so no digits are lost
+func decodeJSON(body []byte, _ map[string]string) (any, error) {
+	var doc any
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func decodeMsgPack(body []byte, _ map[string]string) (any, error) {
+	var doc any
+	if err := msgpack.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	return normalize(doc), nil
+}
+
+func decodeCBOR(body []byte, _ map[string]string) (any, error) {
+	var doc any
+	if err := cbor.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	return normalize(doc), nil
+}
+
+// normalize - converts decoded value to the shape of decoded JSON, integers become json.Number,
+// byte strings and timestamps become strings, map keys are formatted when they are not strings
+func normalize(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = normalize(e)
+		}
+		return t
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			ks, ok := k.(string)
+			if !ok {
+				ks = fmt.Sprint(normalize(k))
+			}
+			m[ks] = normalize(e)
+		}
+		return m
+	case []any:
+		for i := range t {
+			t[i] = normalize(t[i])
+		}
+		return t
+	case int:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int8:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int16:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int32:
+		return json.Number(strconv.FormatInt(int64(t), 10))
+	case int64:
+		return json.Number(strconv.FormatInt(t, 10))
+	case uint:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint8:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint16:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint32:
+		return json.Number(strconv.FormatUint(uint64(t), 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(t, 10))
+	case big.Int:
+		return json.Number(t.String())
+	case *big.Int:
+		return json.Number(t.String())
+	case float32:
+		// shortest representation of float32 value, 1.1 stays 1.1 instead of 1.100000023841858
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(t), 'g', -1, 32), 64)
+		return f
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
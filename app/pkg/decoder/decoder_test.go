@@ -0,0 +1,146 @@
+package decoder
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newTestRegistry(t *testing.T) Registry {
+	t.Helper()
+	r, err := New(ContentTypeJSON, "testdata/order.pb", "shop.Order", "testdata/order.avsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRegistryContentTypes(t *testing.T) {
+	r := newTestRegistry(t)
+	msgPackBody, _ := msgpack.Marshal(map[string]any{"id": "order-1"})
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		json        bool
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "json suffix", contentType: "application/vnd.order+json", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "upper case", contentType: "Application/JSON", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "missing content type", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "text is decoded as default", contentType: "text/plain", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "octet stream is decoded as default", contentType: "application/octet-stream", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "invalid content type is decoded as default", contentType: "json;;", body: []byte(`{"id":"order-1"}`), json: true},
+		{name: "msgpack", contentType: "application/x-msgpack", body: msgPackBody},
+		{name: "msgpack suffix", contentType: "application/vnd.order+msgpack", body: msgPackBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.JSON(tt.contentType); got != tt.json {
+				t.Errorf("JSON(%q) = %v, want %v", tt.contentType, got, tt.json)
+			}
+			doc, err := r.Decode(tt.contentType, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]any{"id": "order-1"}; !reflect.DeepEqual(doc, want) {
+				t.Errorf("decoded %#v, want %#v", doc, want)
+			}
+		})
+	}
+}
+
+func TestNewDefaultWithoutDecoder(t *testing.T) {
+	if _, err := New(ContentTypeAvro, "", "", ""); err == nil {
+		t.Error("avro default content type without schema was accepted")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 500, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		name string
+		in   any
+		want any
+	}{
+		{name: "signed integers", in: []any{int(-1), int8(-8), int16(-16), int32(-32), int64(-64)},
+			want: []any{json.Number("-1"), json.Number("-8"), json.Number("-16"), json.Number("-32"), json.Number("-64")}},
+		{name: "unsigned integers", in: []any{uint(1), uint8(8), uint16(16), uint32(32), uint64(18446744073709551615)},
+			want: []any{json.Number("1"), json.Number("8"), json.Number("16"), json.Number("32"), json.Number("18446744073709551615")}},
+		{name: "big integers", in: []any{*big.NewInt(7), new(big.Int).Lsh(big.NewInt(1), 70)},
+			want: []any{json.Number("7"), json.Number("1180591620717411303424")}},
+		{name: "float32 keeps shortest representation", in: float32(1.1), want: 1.1},
+		{name: "float64", in: 9.5, want: 9.5},
+		{name: "bytes", in: []byte("order-1"), want: "order-1"},
+		{name: "time", in: created, want: "2024-05-01T10:30:00.0000005Z"},
+		{name: "non string map keys", in: map[any]any{int64(1): "a", true: "b", "c": uint8(3)},
+			want: map[string]any{"1": "a", "true": "b", "c": json.Number("3")}},
+		{name: "nested", in: map[string]any{"lines": []any{map[any]any{"qty": int8(2)}}},
+			want: map[string]any{"lines": []any{map[string]any{"qty": json.Number("2")}}}},
+		{name: "other values", in: []any{"s", true, nil}, want: []any{"s", true, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalize() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// order - the same document in every format, decoded documents have to equal wantOrder
+var order = map[string]any{
+	"id":       "order-1",
+	"amount":   42,
+	"customer": map[string]any{"name": "acme", "tier": "GOLD"},
+	"tags":     []any{"a", "b"},
+	"lines":    []any{map[string]any{"sku": "x-1", "quantity": 1}, map[string]any{"sku": "x-2", "quantity": 3}},
+	"price":    9.5,
+	"paid":     true,
+}
+
+var wantOrder = map[string]any{
+	"id":       "order-1",
+	"amount":   json.Number("42"),
+	"customer": map[string]any{"name": "acme", "tier": "GOLD"},
+	"tags":     []any{"a", "b"},
+	"lines": []any{
+		map[string]any{"sku": "x-1", "quantity": json.Number("1")},
+		map[string]any{"sku": "x-2", "quantity": json.Number("3")},
+	},
+	"price": 9.5,
+	"paid":  true,
+}
+
+func TestDecodeMsgPackAndCBOR(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := []struct {
+		name        string
+		contentType string
+		marshal     func(v any) ([]byte, error)
+	}{
+		{name: "msgpack", contentType: ContentTypeMsgPack, marshal: msgpack.Marshal},
+		{name: "cbor", contentType: ContentTypeCBOR, marshal: cbor.Marshal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := tt.marshal(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := r.Decode(tt.contentType, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, wantOrder) {
+				t.Errorf("decoded %#v, want %#v", doc, wantOrder)
+			}
+		})
+	}
+}
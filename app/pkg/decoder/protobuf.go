@@ -0,0 +1,77 @@
+package decoder
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoParam - content type parameter with full name of body message type, e.g. application/x-protobuf; proto=shop.Order
+const ProtoParam = "proto"
+
+// newProtobuf - loads descriptor set file produced by protoc --descriptor_set_out --include_imports.
+// Message is turned into JSON with proto field names, so paths use names from .proto files,
+// 64-bit integers and enums become strings, fields with default values are missing.
+func newProtobuf(path, message string) (Decoder, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptor set %s: %w", path, err)
+	}
+	find := func(name string) (protoreflect.MessageDescriptor, error) {
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("protobuf message %q: %w", name, err)
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("protobuf descriptor %q is not a message", name)
+		}
+		return md, nil
+	}
+	var fallback protoreflect.MessageDescriptor
+	if message != "" {
+		if fallback, err = find(message); err != nil {
+			return nil, err
+		}
+	}
+	types := dynamicpb.NewTypes(files)
+	opts := protojson.MarshalOptions{UseProtoNames: true, Resolver: types}
+	unmarshal := proto.UnmarshalOptions{Resolver: types}
+
+	return func(body []byte, params map[string]string) (any, error) {
+		md := fallback
+		if name, ok := params[ProtoParam]; ok {
+			d, err := find(name)
+			if err != nil {
+				return nil, err
+			}
+			md = d
+		}
+		if md == nil {
+			return nil, fmt.Errorf("protobuf message type is unknown, set it by %s content type parameter", ProtoParam)
+		}
+		msg := dynamicpb.NewMessage(md)
+		if err := unmarshal.Unmarshal(body, msg); err != nil {
+			return nil, err
+		}
+		doc, err := opts.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return decodeJSON(doc, nil)
+	}, nil
+}
@@ -0,0 +1,137 @@
+package decoder
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoBody - encodes message of testdata descriptor set given in protojson form
+func protoBody(t *testing.T, message, doc string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile("testdata/order.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(raw, &set); err != nil {
+		t.Fatal(err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+	if err = protojson.Unmarshal([]byte(doc), msg); err != nil {
+		t.Fatal(err)
+	}
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestProtobufDecode(t *testing.T) {
+	orderDoc := `{"id":"order-1","amount":"42","customer":{"name":"acme","tier":"GOLD"},"tags":["a","b"],
+		"lines":[{"sku":"x-1","quantity":1},{"sku":"x-2","quantity":3}],"price":9.5,"paid":true}`
+	tests := []struct {
+		name        string
+		message     string
+		contentType string
+		doc         string
+		want        any
+		wantErr     bool
+	}{
+		{
+			name:        "default message type",
+			message:     "shop.Order",
+			contentType: ContentTypeProtobuf,
+			doc:         orderDoc,
+			// 64-bit integers are strings in protojson, their keys are the same as of JSON numbers
+			want: map[string]any{
+				"id":       "order-1",
+				"amount":   "42",
+				"customer": map[string]any{"name": "acme", "tier": "GOLD"},
+				"tags":     []any{"a", "b"},
+				"lines": []any{
+					map[string]any{"sku": "x-1", "quantity": json.Number("1")},
+					map[string]any{"sku": "x-2", "quantity": json.Number("3")},
+				},
+				"price": json.Number("9.5"),
+				"paid":  true,
+			},
+		},
+		{
+			name:        "default values are missing",
+			message:     "shop.Order",
+			contentType: ContentTypeProtobuf,
+			doc:         `{"id":"order-1","customer":{"name":"acme","tier":"TIER_UNSPECIFIED"},"paid":false}`,
+			want:        map[string]any{"id": "order-1", "customer": map[string]any{"name": "acme"}},
+		},
+		{
+			name:        "message type from content type",
+			message:     "shop.Refund",
+			contentType: "application/protobuf; proto=shop.Refund",
+			doc:         `{"orderId":"order-1"}`,
+			want:        map[string]any{"order_id": "order-1"},
+		},
+		{
+			name:        "unknown message type",
+			message:     "shop.Refund",
+			contentType: "application/x-protobuf; proto=shop.Missing",
+			doc:         `{"orderId":"order-1"}`,
+			wantErr:     true,
+		},
+		{
+			name:        "descriptor which is not a message",
+			message:     "shop.Refund",
+			contentType: "application/x-protobuf; proto=shop.Tier",
+			doc:         `{"orderId":"order-1"}`,
+			wantErr:     true,
+		},
+	}
+	r := newTestRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := r.Decode(tt.contentType, protoBody(t, tt.message, tt.doc))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decoded %#v, want error", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("decoded %#v, want %#v", doc, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtobufWithoutMessageType(t *testing.T) {
+	r, err := New(ContentTypeJSON, "testdata/order.pb", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := r.Decode(ContentTypeProtobuf, protoBody(t, "shop.Refund", `{"orderId":"order-1"}`)); err == nil {
+		t.Errorf("decoded %#v without message type", doc)
+	}
+	if _, err = New(ContentTypeJSON, "testdata/order.pb", "shop.Missing", ""); err == nil {
+		t.Error("unknown default message type was accepted")
+	}
+}
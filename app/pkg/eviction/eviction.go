@@ -0,0 +1,58 @@
+package eviction
+
+import (
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"github.com/dnsx2k/partymq/app/pkg/rabbit"
+	"go.uber.org/zap"
+)
+
+const defaultCheckInterval = time.Minute
+
+type srvContext struct {
+	cache            partition.Cache
+	amqpOrchestrator rabbit.AmqpOrchestrator
+	logger           *zap.Logger
+	idleAfter        time.Duration
+}
+
+// Start - runs background job which evicts idle keys from cache
+func Start(cache partition.Cache, amqpOrch rabbit.AmqpOrchestrator, logger *zap.Logger, idleAfter, checkInterval time.Duration) {
+	if checkInterval <= 0 {
+		checkInterval = defaultCheckInterval
+	}
+	srvCtx := srvContext{
+		cache:            cache,
+		amqpOrchestrator: amqpOrch,
+		logger:           logger,
+		idleAfter:        idleAfter,
+	}
+	go func() {
+		for {
+			<-time.After(checkInterval)
+			srvCtx.evict()
+		}
+	}()
+}
+
+func (srv *srvContext) evict() {
+	n := srv.cache.Evict(srv.idleAfter, srv.drained)
+	if n > 0 {
+		srv.logger.Info("idle keys evicted", zap.Int("count", n))
+	}
+}
+
+// drained - reports whether partition queue has no messages waiting,
+// keys of clients which did not declare their queue are never evicted
+func (srv *srvContext) drained(p partition.Partition) bool {
+	if p.Queue == "" {
+		return false
+	}
+	q, err := srv.amqpOrchestrator.InspectQueue(p.Queue)
+	if err != nil {
+		srv.logger.Warn("can not inspect partition queue", zap.String("queue", p.Queue), zap.Error(err))
+		return false
+	}
+	return q.Messages == 0
+}
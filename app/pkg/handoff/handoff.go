@@ -0,0 +1,482 @@
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/helpers"
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"github.com/dnsx2k/partymq/app/pkg/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// KeyHeader - header with partition key of parked message
+	KeyHeader = "x-partymq-key"
+	// GroupHeader - header with client group of parked message
+	GroupHeader = "x-partymq-group"
+
+	parkingQueuePrefix = "partymq.q.parking"
+	// parkingIndexQueue - names of parking queues, so parked messages left by previous run are found on startup
+	parkingIndexQueue = "partymq.q.parking-index"
+)
+
+// Handoff - keeps per-key ordering while keys move between clients.
+// Moved key is fenced, its new messages are parked until previous owner drains its queue
+// or confirms release, afterwards parked messages are forwarded to the current owner.
+type Handoff interface {
+	// Listen - partition.Listener, fences moved keys
+	Listen(e partition.Event)
+	// Park - returns parking queue for fenced key (partition.GroupKey), parked message has to be published there or canceled
+	Park(key string) (Parking, bool, error)
+	// Release - previous owner confirms it has no in-flight messages of moved keys
+	Release(hostname string)
+	// Recover - starts forwarding messages left in parking queues by previous run to current key owners
+	Recover() error
+	// Recovered - reports whether messages parked by previous run were forwarded, new messages of their keys
+	// must not be consumed before, they would overtake them
+	Recovered() bool
+}
+
+// Parking - place reserved for single message in parking queue
+type Parking struct {
+	Queue string
+	t     *transfer
+}
+
+// Cancel - gives back reservation when parked message could not be published
+func (p Parking) Cancel() {
+	p.t.parked.Add(-1)
+}
+
+// transfer - keys moved away from a single client
+type transfer struct {
+	from      partition.Partition
+	queue     string
+	keys      map[string]struct{}
+	parked    atomic.Int64
+	created   time.Time
+	declared  bool
+	declMutex sync.Mutex
+	empty     int
+}
+
+type handoffCtx struct {
+	cache            partition.Cache
+	amqpOrchestrator rabbit.AmqpOrchestrator
+	logger           *zap.Logger
+	partitions       map[string]partition.Partition
+	open             map[string]*transfer
+	fenced           map[string]*transfer
+	seq              int
+	releaseTimeout   time.Duration
+	mutex            sync.RWMutex
+	// queues - parking queues which were not completed yet, index queue lists exactly them after compaction
+	queues     map[string]struct{}
+	indexMutex sync.Mutex
+	recovering atomic.Int64
+}
+
+// New - creation function, starts background job which releases transfers of drained clients
+func New(cache partition.Cache, amqpOrch rabbit.AmqpOrchestrator, logger *zap.Logger, checkInterval, releaseTimeout time.Duration) Handoff {
+	hCtx := &handoffCtx{
+		cache:            cache,
+		amqpOrchestrator: amqpOrch,
+		logger:           logger,
+		partitions:       cache.Snapshot().Clients,
+		open:             make(map[string]*transfer),
+		fenced:           make(map[string]*transfer),
+		queues:           make(map[string]struct{}),
+		releaseTimeout:   releaseTimeout,
+		mutex:            sync.RWMutex{},
+	}
+	go func() {
+		for {
+			<-time.After(checkInterval)
+			hCtx.check()
+		}
+	}()
+
+	return hCtx
+}
+
+func (h *handoffCtx) Listen(e partition.Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch e.Type {
+	case partition.EventReady, partition.EventUpdate:
+		h.partitions[e.Hostname] = e.Partition
+	case partition.EventDelete:
+		// messages of deleted client won't be processed anymore, there is nothing to wait for
+		delete(h.partitions, e.Hostname)
+		if t, ok := h.open[e.Hostname]; ok {
+			h.release(t)
+		}
+	case partition.EventSuspend, partition.EventResume:
+		// suspended client keeps its keys and queue, transfers from it wait until it drains or is deleted
+		return
+	case partition.EventAssign:
+		if e.From == "" {
+			return
+		}
+		// key moved again before release keeps waiting for the client which received its messages
+		if _, ok := h.fenced[e.Key]; ok {
+			return
+		}
+		t, ok := h.open[e.From]
+		if !ok {
+			from := h.partitions[e.From]
+			from.Hostname = e.From
+			h.seq++
+			t = &transfer{
+				from:    from,
+				queue:   fmt.Sprintf("%s.%s.%d", parkingQueuePrefix, e.From, h.seq),
+				keys:    make(map[string]struct{}),
+				created: time.Now(),
+			}
+			h.open[e.From] = t
+		}
+		t.keys[e.Key] = struct{}{}
+		h.fenced[e.Key] = t
+	}
+}
+
+func (h *handoffCtx) Park(key string) (Parking, bool, error) {
+	h.mutex.RLock()
+	t, ok := h.fenced[key]
+	if !ok {
+		h.mutex.RUnlock()
+		return Parking{}, false, nil
+	}
+	// reservation is made under read lock, so transfer can't be completed in between
+	t.parked.Add(1)
+	h.mutex.RUnlock()
+
+	if err := h.declare(t); err != nil {
+		t.parked.Add(-1)
+		return Parking{}, false, err
+	}
+
+	return Parking{Queue: t.queue, t: t}, true, nil
+}
+
+func (h *handoffCtx) Release(hostname string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if t, ok := h.open[hostname]; ok {
+		h.release(t)
+	}
+}
+
+// release - closes transfer for new keys and starts forwarding, caller must hold write lock
+func (h *handoffCtx) release(t *transfer) {
+	delete(h.open, t.from.Hostname)
+	h.logger.Info("keys released", zap.String("hostname", t.from.Hostname), zap.Int("keys", len(t.keys)), zap.Int64("parked", t.parked.Load()))
+	go h.forward(t)
+}
+
+func (h *handoffCtx) declare(t *transfer) error {
+	t.declMutex.Lock()
+	defer t.declMutex.Unlock()
+	if t.declared {
+		return nil
+	}
+	ch, err := h.amqpOrchestrator.GetChannel(rabbit.DirectionPrimary)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if _, err = ch.QueueDeclare(t.queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err = h.index(ch, t.queue); err != nil {
+		return err
+	}
+	t.declared = true
+
+	return nil
+}
+
+// index - records parking queue name, parking queue is durable and its messages have to be found after restart
+func (h *handoffCtx) index(ch *amqp.Channel, queue string) error {
+	h.indexMutex.Lock()
+	defer h.indexMutex.Unlock()
+	if _, err := ch.QueueDeclare(parkingIndexQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := publishName(ch, queue); err != nil {
+		return err
+	}
+	h.queues[queue] = struct{}{}
+	return nil
+}
+
+// unindex - drops name of completed parking queue from the index
+func (h *handoffCtx) unindex(ch *amqp.Channel, queue string) error {
+	h.indexMutex.Lock()
+	defer h.indexMutex.Unlock()
+	delete(h.queues, queue)
+	q, err := h.amqpOrchestrator.InspectQueue(parkingIndexQueue)
+	if err != nil {
+		return err
+	}
+	return h.compact(ch, q.Messages)
+}
+
+// compact - publishes names of pending parking queues and drops the first n index entries, which were
+// there before. Names are published first, so a crash in between leaves duplicates rather than gaps.
+// Caller must hold index mutex.
+func (h *handoffCtx) compact(ch *amqp.Channel, n int) error {
+	for queue := range h.queues {
+		if err := publishName(ch, queue); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		msg, ok, err := ch.Get(parkingIndexQueue, false)
+		if err != nil || !ok {
+			return err
+		}
+		if err = msg.Ack(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func publishName(ch *amqp.Channel, queue string) error {
+	return ch.PublishWithContext(context.Background(), "", parkingIndexQueue, false, false, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Body:         []byte(queue),
+	})
+}
+
+func (h *handoffCtx) Recover() error {
+	h.indexMutex.Lock()
+	defer h.indexMutex.Unlock()
+	ch, err := h.amqpOrchestrator.GetChannel(rabbit.DirectionPrimary)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if _, err = ch.QueueDeclare(parkingIndexQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	q, err := h.amqpOrchestrator.InspectQueue(parkingIndexQueue)
+	if err != nil {
+		return err
+	}
+	// entries are read without ack, compaction acks them after names of leftovers are published again
+	leftovers := make([]*transfer, 0)
+	for i := 0; i < q.Messages; i++ {
+		msg, ok, err := ch.Get(parkingIndexQueue, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		queue := string(msg.Body)
+		if _, dup := h.queues[queue]; dup {
+			continue
+		}
+		parked, err := h.amqpOrchestrator.InspectQueue(queue)
+		switch {
+		case err != nil:
+			// parking queue was deleted once its messages were forwarded
+		case parked.Messages == 0:
+			_, _ = ch.QueueDelete(queue, false, true, false)
+		default:
+			t := &transfer{queue: queue, keys: make(map[string]struct{}), declared: true, created: time.Now()}
+			t.parked.Store(int64(parked.Messages))
+			h.queues[queue] = struct{}{}
+			leftovers = append(leftovers, t)
+		}
+	}
+	// unacked entries return to the index queue once the channel is closed, they are acked here instead
+	for queue := range h.queues {
+		if err = publishName(ch, queue); err != nil {
+			return err
+		}
+	}
+	if q.Messages > 0 {
+		if err = ch.Ack(0, true); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range leftovers {
+		h.logger.Info("forwarding messages parked by previous run", zap.String("queue", t.queue), zap.Int64("parked", t.parked.Load()))
+		h.recovering.Add(1)
+		go func(t *transfer) {
+			defer h.recovering.Add(-1)
+			h.forward(t)
+		}(t)
+	}
+
+	return nil
+}
+
+func (h *handoffCtx) Recovered() bool {
+	return h.recovering.Load() == 0
+}
+
+// check - releases transfers whose previous owner drained its queue or which are waiting too long
+func (h *handoffCtx) check() {
+	h.mutex.RLock()
+	transfers := make([]*transfer, 0, len(h.open))
+	for _, t := range h.open {
+		transfers = append(transfers, t)
+	}
+	h.mutex.RUnlock()
+
+	for _, t := range transfers {
+		drained := h.drained(t)
+		h.mutex.Lock()
+		if h.open[t.from.Hostname] == t {
+			switch {
+			case drained:
+				h.release(t)
+			case h.releaseTimeout > 0 && time.Since(t.created) > h.releaseTimeout:
+				h.logger.Warn("keys released after timeout", zap.String("hostname", t.from.Hostname))
+				h.release(t)
+			}
+		}
+		h.mutex.Unlock()
+	}
+}
+
+// drained - queue has to be seen empty by two consecutive checks, so messages delivered
+// but not acked during the first one have a full check interval to be processed
+func (h *handoffCtx) drained(t *transfer) bool {
+	if t.from.Queue == "" {
+		return false
+	}
+	q, err := h.amqpOrchestrator.InspectQueue(t.from.Queue)
+	if err != nil || q.Messages > 0 {
+		t.empty = 0
+		return false
+	}
+	t.empty++
+
+	return t.empty >= 2
+}
+
+// forward - moves parked messages to current key owners, unfences keys when nothing is parked anymore
+func (h *handoffCtx) forward(t *transfer) {
+	for {
+		if err := h.forwardParked(t); err != nil {
+			h.logger.Error("error occurred while forwarding parked messages", zap.String("queue", t.queue), zap.Error(err))
+			<-time.After(time.Second)
+			continue
+		}
+		return
+	}
+}
+
+func (h *handoffCtx) forwardParked(t *transfer) error {
+	if h.complete(t) {
+		return nil
+	}
+	if err := h.declare(t); err != nil {
+		return err
+	}
+	sub, err := h.amqpOrchestrator.GetChannel(rabbit.DirectionSub)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	pub, err := h.amqpOrchestrator.GetChannel(rabbit.DirectionPub)
+	if err != nil {
+		return err
+	}
+	defer pub.Close()
+	// forwarded message has to be enqueued before keys are unfenced, otherwise it could be overtaken
+	if err = pub.Confirm(false); err != nil {
+		return err
+	}
+	msgs, err := sub.Consume(t.queue, "", false, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for msg := range msgs {
+		key, _ := msg.Headers[KeyHeader].(string)
+		group, _ := msg.Headers[GroupHeader].(string)
+		owner, err := h.owner(group, key)
+		if err != nil {
+			_ = msg.Nack(false, true)
+			return err
+		}
+		delete(msg.Headers, KeyHeader)
+		delete(msg.Headers, GroupHeader)
+		msg.Headers[rabbit.EpochHeader] = int64(owner.Epoch)
+		p := helpers.WrapAmqpDelivery(&msg)
+		p.Headers = msg.Headers
+		confirmation, err := pub.PublishWithDeferredConfirmWithContext(ctx, rabbit.PartyMqExchange, owner.RoutingKey, false, false, p)
+		if err != nil {
+			_ = msg.Nack(false, true)
+			return err
+		}
+		if ok, err := confirmation.WaitContext(ctx); err != nil || !ok {
+			_ = msg.Nack(false, true)
+			return errors.New("forwarded message was not confirmed")
+		}
+		_ = msg.Ack(false)
+		t.parked.Add(-1)
+		if h.complete(t) {
+			return nil
+		}
+	}
+
+	return errors.New("parking queue consumer closed")
+}
+
+func (h *handoffCtx) owner(group, key string) (partition.Partition, error) {
+	owner, err := h.cache.GetRoutingKey(group, key)
+	if err != nil {
+		return partition.Partition{}, err
+	}
+	if owner.RoutingKey == "" {
+		return h.cache.AssignToFreePartition(group, key)
+	}
+	return owner, nil
+}
+
+// complete - unfences keys of released transfer when all its parked messages were forwarded
+func (h *handoffCtx) complete(t *transfer) bool {
+	h.mutex.Lock()
+	if t.parked.Load() > 0 {
+		h.mutex.Unlock()
+		return false
+	}
+	for k := range t.keys {
+		if h.fenced[k] == t {
+			delete(h.fenced, k)
+		}
+	}
+	h.mutex.Unlock()
+
+	t.declMutex.Lock()
+	declared := t.declared
+	t.declMutex.Unlock()
+	if declared {
+		if ch, err := h.amqpOrchestrator.GetChannel(rabbit.DirectionPrimary); err == nil {
+			_, _ = ch.QueueDelete(t.queue, false, true, false)
+			if err = h.unindex(ch, t.queue); err != nil {
+				h.logger.Warn("parking index not compacted", zap.String("queue", t.queue), zap.Error(err))
+			}
+			_ = ch.Close()
+		}
+	}
+	h.logger.Info("keys handed off", zap.String("hostname", t.from.Hostname), zap.Int("keys", len(t.keys)))
+
+	return true
+}
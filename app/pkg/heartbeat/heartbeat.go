@@ -10,23 +10,30 @@ import (
 
 type HeartBeater interface {
 	Beat(hostname string)
+	Expiries() map[string]time.Time
+	Restore(expiry map[string]time.Time, hostnames []string)
 }
 
 type srvContext struct {
 	cache     partition.Cache
 	expiry    map[string]time.Time
+	absent    map[string]time.Time
 	mutex     sync.Mutex
 	logger    *zap.Logger
 	clientTTL time.Duration
+	grace     time.Duration
 }
 
-func New(cache partition.Cache, logger *zap.Logger, clientTTL, checkInterval time.Duration) HeartBeater {
+// New - creation function, expired client keeps its keys for grace period, so it gets them back when it binds again in time
+func New(cache partition.Cache, logger *zap.Logger, clientTTL, checkInterval, grace time.Duration) HeartBeater {
 	srvCtx := srvContext{
 		cache:     cache,
 		expiry:    make(map[string]time.Time),
+		absent:    make(map[string]time.Time),
 		mutex:     sync.Mutex{},
 		logger:    logger,
 		clientTTL: clientTTL,
+		grace:     grace,
 	}
 	go func() {
 		for {
@@ -38,11 +45,42 @@ func New(cache partition.Cache, logger *zap.Logger, clientTTL, checkInterval tim
 	return &srvCtx
 }
 
+// Beat - prolongs client TTL, suspended client which beats again is returned into assignment.
+// Cache is asked even if client isn't known as absent here, suspension could be restored from state store.
 func (srv *srvContext) Beat(hostname string) {
 	srv.mutex.Lock()
 	defer srv.mutex.Unlock()
 
 	srv.expiry[hostname] = time.Now().Add(srv.clientTTL)
+	delete(srv.absent, hostname)
+	// pending clients beat as well, they are not in assignment yet
+	_ = srv.cache.Resume(hostname)
+}
+
+// Expiries - returns copy of clients expiry times
+func (srv *srvContext) Expiries() map[string]time.Time {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	e := make(map[string]time.Time, len(srv.expiry))
+	for hostname, t := range srv.expiry {
+		e[hostname] = t
+	}
+	return e
+}
+
+// Restore - loads persisted expiry times, restored clients without one get a fresh TTL
+func (srv *srvContext) Restore(expiry map[string]time.Time, hostnames []string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	for _, hostname := range hostnames {
+		if t, ok := expiry[hostname]; ok {
+			srv.expiry[hostname] = t
+			continue
+		}
+		srv.expiry[hostname] = time.Now().Add(srv.clientTTL)
+	}
 }
 
 func (srv *srvContext) check() {
@@ -52,9 +90,21 @@ func (srv *srvContext) check() {
 	now := time.Now()
 	for hostname, expiry := range srv.expiry {
 		if now.After(expiry) {
-			srv.cache.Delete(hostname)
 			delete(srv.expiry, hostname)
+			if srv.grace > 0 && srv.cache.Suspend(hostname) == nil {
+				srv.absent[hostname] = now.Add(srv.grace)
+				srv.logger.Info("client suspended", zap.String("hostname", hostname), zap.Duration("grace", srv.grace))
+				continue
+			}
+			_ = srv.cache.Delete(hostname, 0)
 			srv.logger.Info("client expired", zap.String("hostname", hostname))
 		}
 	}
+	for hostname, deadline := range srv.absent {
+		if now.After(deadline) {
+			delete(srv.absent, hostname)
+			_ = srv.cache.Delete(hostname, 0)
+			srv.logger.Info("client expired after grace period", zap.String("hostname", hostname))
+		}
+	}
 }
@@ -14,3 +14,12 @@ func WrapAmqpPublishing(msg []byte) amqp.Publishing {
 		Body:      msg,
 	}
 }
+
+// WrapAmqpDelivery - returns amqp publishing with body of consumed message, content type and encoding
+// are kept, so the body can still be read by its receiver
+func WrapAmqpDelivery(d *amqp.Delivery) amqp.Publishing {
+	p := WrapAmqpPublishing(d.Body)
+	p.ContentType = d.ContentType
+	p.ContentEncoding = d.ContentEncoding
+	return p
+}
@@ -0,0 +1,155 @@
+package hotkey
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+const (
+	defaultCapacity = 1000
+	defaultWindow   = time.Minute
+)
+
+// Hit - key with its message count in the last complete window
+type Hit struct {
+	Group string `json:"group,omitempty"`
+	Key   string `json:"key"`
+	// Count - estimated number of messages, never lower than the real one
+	Count int64 `json:"count"`
+	// Error - upper bound of Count overestimation
+	Error int64 `json:"error"`
+	// Rate - estimated messages per second
+	Rate float64 `json:"rate"`
+}
+
+// Tracker - streaming heavy hitters of partition keys, memory is bounded by capacity regardless of key cardinality
+type Tracker interface {
+	// Observe - counts message of the key (partition.GroupKey)
+	Observe(key string)
+	// Top - returns at most n keys with the highest rate in the last complete window, n <= 0 returns all tracked keys
+	Top(n int) []Hit
+}
+
+// entry - space-saving counter
+type entry struct {
+	key   string
+	count int64
+	error int64
+	index int
+}
+
+// summary - min-heap of counters ordered by count
+type summary []*entry
+
+func (s summary) Len() int           { return len(s) }
+func (s summary) Less(i, j int) bool { return s[i].count < s[j].count }
+func (s summary) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+func (s *summary) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*s)
+	*s = append(*s, e)
+}
+func (s *summary) Pop() any {
+	old := *s
+	e := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return e
+}
+
+type trackerCtx struct {
+	capacity int
+	window   time.Duration
+	entries  map[string]*entry
+	summary  summary
+	last     []Hit
+	mutex    sync.Mutex
+}
+
+// New - creation function, tracks at most capacity keys with space-saving algorithm, counters are reset every window
+func New(capacity int, window time.Duration) Tracker {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if window <= 0 {
+		window = defaultWindow
+	}
+	tCtx := &trackerCtx{
+		capacity: capacity,
+		window:   window,
+		entries:  make(map[string]*entry, capacity),
+		summary:  make(summary, 0, capacity),
+		last:     make([]Hit, 0),
+	}
+	go func() {
+		for {
+			<-time.After(window)
+			tCtx.rotate()
+		}
+	}()
+
+	return tCtx
+}
+
+func (t *trackerCtx) Observe(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if e, ok := t.entries[key]; ok {
+		e.count++
+		heap.Fix(&t.summary, e.index)
+		return
+	}
+	if len(t.summary) < t.capacity {
+		e := &entry{key: key, count: 1}
+		t.entries[key] = e
+		heap.Push(&t.summary, e)
+		return
+	}
+	// least counted key is replaced, new key inherits its count as possible overestimation
+	e := t.summary[0]
+	delete(t.entries, e.key)
+	e.key = key
+	e.error = e.count
+	e.count++
+	t.entries[key] = e
+	heap.Fix(&t.summary, 0)
+}
+
+func (t *trackerCtx) Top(n int) []Hit {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if n <= 0 || n > len(t.last) {
+		n = len(t.last)
+	}
+	top := make([]Hit, n)
+	copy(top, t.last)
+	return top
+}
+
+// rotate - closes current window, its counters become the result of Top
+func (t *trackerCtx) rotate() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	hits := make([]Hit, 0, len(t.summary))
+	for _, e := range t.summary {
+		group, key := partition.SplitGroupKey(e.key)
+		hits = append(hits, Hit{
+			Group: group,
+			Key:   key,
+			Count: e.count,
+			Error: e.error,
+			Rate:  float64(e.count) / t.window.Seconds(),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Count > hits[j].Count })
+	t.last = hits
+	t.entries = make(map[string]*entry, t.capacity)
+	t.summary = t.summary[:0]
+}
@@ -0,0 +1,123 @@
+package hotkey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"go.uber.org/zap"
+)
+
+// Isolation policies, applied on keys whose rate exceeds threshold
+const (
+	PolicyNone        = "none"
+	PolicyLeastLoaded = "least-loaded"
+	PolicyDedicated   = "dedicated"
+)
+
+type isolationCtx struct {
+	tracker   Tracker
+	cache     partition.Cache
+	logger    *zap.Logger
+	label     string
+	threshold float64
+	window    time.Duration
+	// lastHot - when key of hot rule was seen above threshold, rules of keys cold for a whole window are deleted
+	lastHot map[string]time.Time
+}
+
+// StartIsolation - runs background job which pins hot keys to the least loaded client of their group,
+// with dedicated policy only clients with label (key=value) are considered. Every other key stays where it is.
+// Rule of a key which stays below threshold for a whole check interval is deleted, the key stays on its client.
+func StartIsolation(tracker Tracker, cache partition.Cache, logger *zap.Logger, policy, label string, threshold float64, checkInterval time.Duration) error {
+	switch policy {
+	case PolicyNone:
+		return nil
+	case PolicyLeastLoaded:
+		label = ""
+	case PolicyDedicated:
+		if label == "" {
+			return errors.New("dedicated hot key policy requires client label")
+		}
+	default:
+		return fmt.Errorf("unknown hot key policy: %s", policy)
+	}
+	if threshold <= 0 {
+		return errors.New("hot key threshold has to be positive")
+	}
+	if checkInterval <= 0 {
+		checkInterval = defaultWindow
+	}
+	iCtx := isolationCtx{
+		tracker:   tracker,
+		cache:     cache,
+		logger:    logger,
+		label:     label,
+		threshold: threshold,
+		window:    checkInterval,
+		lastHot:   make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			<-time.After(checkInterval)
+			iCtx.cool(iCtx.isolate())
+		}
+	}()
+
+	return nil
+}
+
+// isolate - pins hot keys, returns ids of rules of keys which are hot now
+func (i *isolationCtx) isolate() map[string]struct{} {
+	hot := make(map[string]struct{})
+	for _, hit := range i.tracker.Top(0) {
+		// hits are sorted, the rest is colder
+		if hit.Rate < i.threshold {
+			return hot
+		}
+		hot[partition.HotRuleID(hit.Group, hit.Key)] = struct{}{}
+		hostname, moved, err := i.cache.Isolate(partition.GroupKey(hit.Group, hit.Key), i.label)
+		if err != nil {
+			i.logger.Warn("can not isolate hot key", zap.String("group", hit.Group), zap.String("key", hit.Key), zap.Error(err))
+			continue
+		}
+		if moved {
+			i.logger.Info("hot key isolated", zap.String("group", hit.Group), zap.String("key", hit.Key), zap.String("hostname", hostname), zap.Float64("rate", hit.Rate))
+		}
+	}
+	return hot
+}
+
+// cool - deletes hot rules whose key was not hot for a whole window, rules restored from state are given one
+func (i *isolationCtx) cool(hot map[string]struct{}) {
+	now := time.Now()
+	rules := make(map[string]struct{})
+	for _, r := range i.cache.Rules() {
+		if !strings.HasPrefix(r.ID, partition.HotRulePrefix) {
+			continue
+		}
+		rules[r.ID] = struct{}{}
+		last, seen := i.lastHot[r.ID]
+		if _, ok := hot[r.ID]; ok || !seen {
+			i.lastHot[r.ID] = now
+			continue
+		}
+		if now.Sub(last) < i.window {
+			continue
+		}
+		if err := i.cache.DeleteRule(r.ID); err != nil {
+			i.logger.Warn("can not delete rule of cooled down key", zap.String("rule", r.ID), zap.Error(err))
+			continue
+		}
+		delete(i.lastHot, r.ID)
+		i.logger.Info("hot key cooled down", zap.String("rule", r.ID))
+	}
+	// rules deleted by API are forgotten
+	for id := range i.lastHot {
+		if _, ok := rules[id]; !ok {
+			delete(i.lastHot, id)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package load
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/hotkey"
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"github.com/dnsx2k/partymq/app/pkg/rabbit"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCheckInterval = 30 * time.Second
+	defaultMaxMoves      = 10
+)
+
+type srvContext struct {
+	cache            partition.Cache
+	amqpOrchestrator rabbit.AmqpOrchestrator
+	meter            Meter
+	hotKeys          hotkey.Tracker
+	logger           *zap.Logger
+	threshold        float64
+	maxMoves         int
+	interval         time.Duration
+}
+
+// client - load of a single client in the current cycle, messages are counted per unit of weight
+type client struct {
+	partition.Partition
+	load float64
+}
+
+// Start - runs background job which samples queue depth and forward rate of every client and moves keys
+// from clients whose load exceeds the group average by more than threshold (e.g. 0.25) to underloaded ones.
+// Keys are picked by their rate from hot key tracker, at most maxMoves keys are moved per cycle.
+func Start(cache partition.Cache, amqpOrch rabbit.AmqpOrchestrator, meter Meter, hotKeys hotkey.Tracker, logger *zap.Logger, threshold float64, maxMoves int, checkInterval time.Duration) {
+	if checkInterval <= 0 {
+		checkInterval = defaultCheckInterval
+	}
+	if maxMoves <= 0 {
+		maxMoves = defaultMaxMoves
+	}
+	srvCtx := srvContext{
+		cache:            cache,
+		amqpOrchestrator: amqpOrch,
+		meter:            meter,
+		hotKeys:          hotKeys,
+		logger:           logger,
+		threshold:        threshold,
+		maxMoves:         maxMoves,
+		interval:         checkInterval,
+	}
+	go func() {
+		for {
+			<-time.After(checkInterval)
+			srvCtx.rebalance()
+		}
+	}()
+}
+
+func (srv *srvContext) rebalance() {
+	forwarded := srv.meter.Take()
+	owners := srv.cache.Snapshot().Keys
+	hits := srv.hotKeys.Top(0)
+	moves := 0
+	for group, partitions := range srv.cache.Groups() {
+		if len(partitions) < 2 {
+			continue
+		}
+		moves += srv.balance(group, partitions, forwarded, owners, hits, srv.maxMoves-moves)
+		if moves >= srv.maxMoves {
+			break
+		}
+	}
+	if moves > 0 {
+		srv.logger.Info("keys moved by load", zap.Int("count", moves))
+	}
+}
+
+// balance - moves keys within single group, returns number of moved keys
+func (srv *srvContext) balance(group string, partitions []partition.Partition, forwarded map[string]int64, owners map[string]string, hits []hotkey.Hit, limit int) int {
+	clients := make(map[string]*client, len(partitions))
+	total, weights := 0.0, 0
+	for _, p := range partitions {
+		// backlog and messages forwarded during the last cycle are both work the client has to do
+		messages := float64(forwarded[p.RoutingKey] + srv.depth(p))
+		weight := max(p.Weight, 1)
+		clients[p.Hostname] = &client{Partition: p, load: messages / float64(weight)}
+		total += messages
+		weights += weight
+	}
+	avg := total / float64(weights)
+	if avg == 0 {
+		return 0
+	}
+
+	moves := 0
+	for _, hit := range hits {
+		if moves >= limit {
+			break
+		}
+		if hit.Group != group {
+			continue
+		}
+		key := partition.GroupKey(group, hit.Key)
+		from, ok := clients[owners[key]]
+		if !ok || from.load <= avg*(1+srv.threshold) {
+			continue
+		}
+		to := leastLoaded(clients)
+		traffic := hit.Rate * srv.interval.Seconds()
+		// key must not make the target more loaded than the source was
+		if to.load >= avg || to.load+traffic/float64(max(to.Weight, 1)) >= from.load {
+			continue
+		}
+		if err := srv.cache.Move(key, to.Hostname); err != nil {
+			srv.logger.Debug("key not moved by load", zap.String("key", hit.Key), zap.Error(err))
+			continue
+		}
+		from.load -= traffic / float64(max(from.Weight, 1))
+		to.load += traffic / float64(max(to.Weight, 1))
+		owners[key] = to.Hostname
+		moves++
+	}
+	return moves
+}
+
+// depth - number of messages waiting in client's partition queue, clients without declared queue report 0
+func (srv *srvContext) depth(p partition.Partition) int64 {
+	if p.Queue == "" {
+		return 0
+	}
+	q, err := srv.amqpOrchestrator.InspectQueue(p.Queue)
+	if err != nil {
+		srv.logger.Warn("can not inspect partition queue", zap.String("queue", p.Queue), zap.Error(err))
+		return 0
+	}
+	return int64(q.Messages)
+}
+
+func leastLoaded(clients map[string]*client) *client {
+	sorted := make([]*client, 0, len(clients))
+	for _, c := range clients {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].load == sorted[j].load {
+			return sorted[i].Hostname < sorted[j].Hostname
+		}
+		return sorted[i].load < sorted[j].load
+	})
+	return sorted[0]
+}
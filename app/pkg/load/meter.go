@@ -0,0 +1,35 @@
+package load
+
+import "sync"
+
+// Meter - counts messages forwarded to every partition
+type Meter interface {
+	// Forwarded - counts message published with routing key
+	Forwarded(routingKey string)
+	// Take - returns counts since the previous take and resets them
+	Take() map[string]int64
+}
+
+type meterCtx struct {
+	counts map[string]int64
+	mutex  sync.Mutex
+}
+
+// NewMeter - creation function
+func NewMeter() Meter {
+	return &meterCtx{counts: make(map[string]int64)}
+}
+
+func (m *meterCtx) Forwarded(routingKey string) {
+	m.mutex.Lock()
+	m.counts[routingKey]++
+	m.mutex.Unlock()
+}
+
+func (m *meterCtx) Take() map[string]int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	counts := m.counts
+	m.counts = make(map[string]int64, len(counts))
+	return counts
+}
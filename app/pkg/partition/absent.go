@@ -0,0 +1,59 @@
+package partition
+
+// Suspend - takes expired client out of assignment while it keeps its keys, so a client which comes back
+// gets them back. Messages of its keys wait in its queue, new keys go to other clients of its group.
+// Client becomes ready again when it resumes, with the next incarnation or loses its keys when deleted.
+func (cCtx *cacheCtx) Suspend(hostname string) error {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	h := ClientID(hostname)
+	if _, ok := cCtx.clients[h]; !ok {
+		return ErrClientNotFound
+	}
+	if _, gone := cCtx.absent[h]; gone {
+		return nil
+	}
+	cCtx.suspend(h)
+	cCtx.emit(Event{Type: EventSuspend, Hostname: hostname})
+
+	return nil
+}
+
+// Resume - returns suspended client of the same incarnation into assignment, e.g. when it beats again
+// before its grace period ended. Keys are rebalanced as if the client joined.
+func (cCtx *cacheCtx) Resume(hostname string) error {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	h := ClientID(hostname)
+	p, ok := cCtx.clients[h]
+	if !ok {
+		return ErrClientNotFound
+	}
+	if _, gone := cCtx.absent[h]; !gone {
+		return nil
+	}
+	cCtx.revive(h)
+	cCtx.strategies[cCtx.groupOf(p)].AddClient(h, hostname, p.weight())
+	cCtx.emit(Event{Type: EventResume, Hostname: hostname})
+	cCtx.rebalance(h)
+	cCtx.enforceRules()
+
+	return nil
+}
+
+// suspend - takes client out of assignment, caller must hold write lock
+func (cCtx *cacheCtx) suspend(h ClientID) {
+	group := cCtx.groupOf(cCtx.clients[h])
+	delete(cCtx.members[group], h)
+	cCtx.strategies[group].RemoveClient(h)
+	cCtx.absent[h] = struct{}{}
+}
+
+// revive - returns suspended client into assignment, caller must hold write lock
+func (cCtx *cacheCtx) revive(h ClientID) {
+	if _, gone := cCtx.absent[h]; !gone {
+		return
+	}
+	delete(cCtx.absent, h)
+	cCtx.members[cCtx.groupOf(cCtx.clients[h])][h] = struct{}{}
+}
@@ -0,0 +1,187 @@
+package partition
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule match kinds
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
+var ErrRuleNotFound = errors.New("affinity rule not found")
+
+// Rule - pins keys matched by Pattern to the client with Hostname or to clients with Label (key=value).
+// Rules are evaluated in order before the assignment strategy, the first matching rule with a ready target wins.
+// In slot mode rules are matched against slot keys, e.g. "slot:17". Rules apply in every client group,
+// only clients of the key's group are targeted.
+type Rule struct {
+	ID       string `json:"id"`
+	Match    string `json:"match"`
+	Pattern  string `json:"pattern"`
+	Hostname string `json:"hostname,omitempty"`
+	Label    string `json:"label,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+func compileRule(r Rule) (compiledRule, error) {
+	cr := compiledRule{Rule: r}
+	if r.ID == "" {
+		return cr, errors.New("rule id is required")
+	}
+	if (r.Hostname == "") == (r.Label == "") {
+		return cr, errors.New("rule has to target either hostname or label")
+	}
+	if r.Label != "" && !strings.Contains(r.Label, "=") {
+		return cr, errors.New("rule label has to be in key=value format")
+	}
+	switch r.Match {
+	case MatchExact, MatchPrefix:
+	case MatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return cr, err
+		}
+		cr.re = re
+	default:
+		return cr, fmt.Errorf("unknown rule match: %s", r.Match)
+	}
+	return cr, nil
+}
+
+func (r compiledRule) matches(key string) bool {
+	switch r.Match {
+	case MatchExact:
+		return key == r.Pattern
+	case MatchPrefix:
+		return strings.HasPrefix(key, r.Pattern)
+	case MatchRegex:
+		return r.re.MatchString(key)
+	}
+	return false
+}
+
+func (r compiledRule) targets(p Partition) bool {
+	if r.Hostname != "" {
+		return p.Hostname == r.Hostname
+	}
+	k, v, _ := strings.Cut(r.Label, "=")
+	l, ok := p.Labels[k]
+	return ok && l == v
+}
+
+// pinned - returns ready clients of the group the key is pinned to, false when no rule matches the key
+// with any of its targeted clients ready in the group. Key may be a group key. Caller must hold the lock.
+func (cCtx *cacheCtx) pinned(group, key string) ([]ClientID, bool) {
+	_, key = SplitGroupKey(key)
+	for i := range cCtx.rules {
+		if !cCtx.rules[i].matches(key) {
+			continue
+		}
+		ids := make([]ClientID, 0)
+		for id := range cCtx.members[group] {
+			if cCtx.rules[i].targets(cCtx.clients[id]) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			return ids, true
+		}
+	}
+	return nil, false
+}
+
+// leastLoadedOf - weighted least loaded client of passed ones, caller must hold the lock
+func (cCtx *cacheCtx) leastLoadedOf(ids []ClientID) ClientID {
+	best := ids[0]
+	for _, id := range ids[1:] {
+		if cCtx.counter[id]*cCtx.clients[best].weight() < cCtx.counter[best]*cCtx.clients[id].weight() {
+			best = id
+		}
+	}
+	return best
+}
+
+// enforceRules - moves pinned keys which are not owned by any of their targets, caller must hold write lock
+func (cCtx *cacheCtx) enforceRules() {
+	if len(cCtx.rules) == 0 {
+		return
+	}
+	for group, keys := range cCtx.keys {
+		for k, id := range keys {
+			ids, ok := cCtx.pinned(group, k)
+			if !ok || contains(ids, id) {
+				continue
+			}
+			cCtx.move(k, cCtx.leastLoadedOf(ids))
+		}
+	}
+}
+
+func contains(ids []ClientID, id ClientID) bool {
+	for i := range ids {
+		if ids[i] == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules - returns affinity rules in evaluation order
+func (cCtx *cacheCtx) Rules() []Rule {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	rules := make([]Rule, 0, len(cCtx.rules))
+	for i := range cCtx.rules {
+		rules = append(rules, cCtx.rules[i].Rule)
+	}
+	return rules
+}
+
+// PutRule - adds rule at the end or replaces rule with the same id, pinned keys are moved right away
+func (cCtx *cacheCtx) PutRule(r Rule) error {
+	cr, err := compileRule(r)
+	if err != nil {
+		return err
+	}
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	cCtx.putRule(cr)
+	cCtx.emit(Event{Type: EventRule, Rule: &r})
+	cCtx.enforceRules()
+
+	return nil
+}
+
+// DeleteRule - removes rule, keys it pinned stay where they are
+func (cCtx *cacheCtx) DeleteRule(id string) error {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	for i := range cCtx.rules {
+		if cCtx.rules[i].ID == id {
+			cCtx.rules = append(cCtx.rules[:i], cCtx.rules[i+1:]...)
+			cCtx.emit(Event{Type: EventRuleDelete, Rule: &Rule{ID: id}})
+			return nil
+		}
+	}
+	return ErrRuleNotFound
+}
+
+func (cCtx *cacheCtx) putRule(cr compiledRule) {
+	for i := range cCtx.rules {
+		if cCtx.rules[i].ID == cr.ID {
+			cCtx.rules[i] = cr
+			return
+		}
+	}
+	cCtx.rules = append(cCtx.rules, cr)
+}
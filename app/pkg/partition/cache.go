@@ -3,75 +3,129 @@ package partition
 import (
 	"errors"
 	"hash/fnv"
-	"math"
 	"sync"
+	"time"
 )
 
-var anyClients = false
-
 var (
-	ErrClientNotFound = errors.New("client not found")
+	ErrClientNotFound   = errors.New("client not found")
+	ErrStaleIncarnation = errors.New("client incarnation is stale")
 )
 
 type Cache interface {
-	GetRoutingKey(key string) (string, error)
+	GetRoutingKey(group, key string) (Partition, error)
 	GetPartitions() []string
 
-	AddPending(hostname, routingKey string) error
-	AddReady(hostname string) error
+	AddPending(hostname string, partition Partition) uint64
+	AddReady(hostname string, epoch uint64) error
+	Verify(hostname string, epoch uint64) error
 
 	AnyClients() bool
 
-	AssignToFreePartition(key string) string
-	Delete(hostname string)
+	GetClient(hostname string) (Partition, error)
+	GetHashedPartition(group, seed string) (Partition, error)
+
+	AssignToFreePartition(group, key string) (Partition, error)
+	Slot(key string) (int, bool)
+	Delete(hostname string, epoch uint64) error
+	Suspend(hostname string) error
+	Resume(hostname string) error
+	SetWeight(hostname string, weight int) error
+
+	Evict(idleAfter time.Duration, drained func(p Partition) bool) int
+
+	Subscribe(l Listener)
+	Snapshot() Snapshot
+	Capture(fn func(s Snapshot))
+	Restore(s Snapshot)
+	Check() error
+
+	Rules() []Rule
+	PutRule(r Rule) error
+	DeleteRule(id string) error
+
+	Isolate(key, label string) (string, bool, error)
+	Move(key, hostname string) error
+	Groups() map[string][]Partition
 }
 
 type cacheCtx struct {
-	keys    map[string]uint32
-	counter map[uint32]int
-	clients map[uint32]string
-	pending map[string]string
-	mutex   sync.RWMutex
+	keys        map[string]map[string]ClientID // group -> GroupKey -> owner
+	counter     map[ClientID]int
+	clients     map[ClientID]Partition
+	members     map[string]map[ClientID]struct{}
+	absent      map[ClientID]struct{}
+	pending     map[string]Partition
+	lastSeen    map[string]time.Time
+	marks       map[string]time.Time
+	strategies  map[string]AssignmentStrategy
+	newStrategy func() AssignmentStrategy
+	groupLabel  string
+	epoch       uint64
+	listeners   []Listener
+	slots       int
+	quota       Quota
+	rules       []compiledRule
+	mutex       sync.RWMutex
+	seenMutex   sync.Mutex
 }
 
-func NewCache() Cache {
+// NewCache - creates cache, keys are assigned to clients by strategies created with newStrategy, one per client group.
+// Client group is the value of its groupLabel label, clients without it form the default group.
+// With positive number of slots keys are hashed into slots and slots are assigned instead of keys.
+func NewCache(newStrategy func() AssignmentStrategy, slots int, quota Quota, groupLabel string) Cache {
 	cCtx := cacheCtx{
-		keys:    make(map[string]uint32),
-		counter: make(map[uint32]int),
-		clients: make(map[uint32]string),
-		pending: make(map[string]string),
-		mutex:   sync.RWMutex{},
+		keys:        make(map[string]map[string]ClientID),
+		counter:     make(map[ClientID]int),
+		clients:     make(map[ClientID]Partition),
+		members:     make(map[string]map[ClientID]struct{}),
+		absent:      make(map[ClientID]struct{}),
+		pending:     make(map[string]Partition),
+		lastSeen:    make(map[string]time.Time),
+		marks:       make(map[string]time.Time),
+		strategies:  make(map[string]AssignmentStrategy),
+		newStrategy: newStrategy,
+		groupLabel:  groupLabel,
+		slots:       slots,
+		quota:       quota,
+		mutex:       sync.RWMutex{},
 	}
 
 	return &cCtx
 }
 
-func (cCtx *cacheCtx) GetRoutingKey(key string) (string, error) {
+// GetRoutingKey - returns partition owning the key, empty partition when key is not assigned yet
+func (cCtx *cacheCtx) GetRoutingKey(group, key string) (Partition, error) {
 	cCtx.mutex.RLock()
 	defer cCtx.mutex.RUnlock()
 
-	if len(cCtx.clients) == 0 {
-		return "", ErrClientNotFound
-	}
-
 	// return random partition if there's no key
 	if key == "" {
 		// map iteration will return different result each time, so we can consider as random partition
-		for _, v := range cCtx.clients {
-			return v, nil
+		for id := range cCtx.members[group] {
+			return cCtx.clients[id], nil
 		}
+		return Partition{}, ErrClientNotFound
 	}
 
-	h, ok := cCtx.keys[key]
+	// keys of suspended clients are still routed to them
+	key = GroupKey(group, cCtx.assignmentKey(key))
+	h, ok := cCtx.keys[group][key]
 	if !ok {
-		return "", nil
+		if len(cCtx.members[group]) == 0 {
+			return Partition{}, ErrClientNotFound
+		}
+		return Partition{}, nil
 	}
+	cCtx.touch(key)
 
 	return cCtx.clients[h], nil
 }
 
 func (cCtx *cacheCtx) AnyClients() bool {
-	return anyClients
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	return len(cCtx.clients) > 0
 }
 
 func (cCtx *cacheCtx) GetPartitions() []string {
@@ -79,98 +133,240 @@ func (cCtx *cacheCtx) GetPartitions() []string {
 	defer cCtx.mutex.RUnlock()
 	p := make([]string, 0)
 	for _, v := range cCtx.clients {
-		p = append(p, v)
+		p = append(p, v.RoutingKey)
 	}
 	return p
 }
 
-func (cCtx *cacheCtx) AddPending(hostname, routingKey string) error {
+// AddPending - creates new incarnation of the client, returns its epoch. Newer bind supersedes pending incarnation
+func (cCtx *cacheCtx) AddPending(hostname string, partition Partition) uint64 {
 	cCtx.mutex.Lock()
 	defer cCtx.mutex.Unlock()
-	if _, alreadyPending := cCtx.pending[hostname]; alreadyPending {
-		return errors.New("client already in pending status")
-	}
-	cCtx.pending[hostname] = routingKey
+	cCtx.epoch++
+	partition.Hostname = hostname
+	partition.Epoch = cCtx.epoch
+	cCtx.pending[hostname] = partition
+	cCtx.emit(Event{Type: EventPending, Hostname: hostname, Partition: partition})
 
-	return nil
+	return partition.Epoch
 }
 
-func (cCtx *cacheCtx) AddReady(hostname string) error {
+// AddReady - makes pending incarnation ready, epoch 0 accepts any incarnation.
+// Ready incarnation of the same client is replaced and its keys are kept, unless client changed its group.
+func (cCtx *cacheCtx) AddReady(hostname string, epoch uint64) error {
 	cCtx.mutex.Lock()
 	defer cCtx.mutex.Unlock()
-	h := hash(hostname)
-	if _, alreadyReady := cCtx.counter[h]; alreadyReady {
-		return errors.New("client already in ready status")
-	}
-
-	routingKey, ok := cCtx.pending[hostname]
+	h := ClientID(hostname)
+	partition, ok := cCtx.pending[hostname]
 	if !ok {
 		return errors.New("client not found in pending status")
 	}
+	if epoch != 0 && epoch != partition.Epoch {
+		return ErrStaleIncarnation
+	}
 
-	cCtx.clients[h] = routingKey
-	cCtx.counter[h] = 0
+	if old, ready := cCtx.clients[h]; ready {
+		if cCtx.groupOf(old) == cCtx.groupOf(partition) {
+			cCtx.clients[h] = partition
+			cCtx.revive(h)
+			strategy := cCtx.strategies[cCtx.groupOf(partition)]
+			strategy.RemoveClient(h)
+			strategy.AddClient(h, hostname, partition.weight())
+			cCtx.emit(Event{Type: EventReady, Hostname: hostname, Partition: partition})
+			cCtx.rebalance(h)
+			cCtx.enforceRules()
+			delete(cCtx.pending, hostname)
+			return nil
+		}
+		cCtx.remove(h)
+	}
+
+	cCtx.join(h, partition)
+	cCtx.emit(Event{Type: EventReady, Hostname: hostname, Partition: partition})
 	cCtx.rebalance(h)
+	cCtx.enforceRules()
 
 	delete(cCtx.pending, hostname)
-	if !anyClients {
-		anyClients = true
-	}
 
 	return nil
 }
 
-func (cCtx *cacheCtx) AssignToFreePartition(key string) string {
-	c := math.MaxInt
+func (cCtx *cacheCtx) AssignToFreePartition(group, key string) (Partition, error) {
 	cCtx.mutex.Lock()
 	defer cCtx.mutex.Unlock()
-	var h uint32
-	for k, v := range cCtx.counter {
-		if c > v {
-			c = v
-			h = k
-		}
+	if len(cCtx.members[group]) == 0 {
+		return Partition{}, ErrClientNotFound
 	}
-	cCtx.keys[key] = h
+	key = GroupKey(group, cCtx.assignmentKey(key))
+	// key could be assigned in the meantime by another sender
+	if h, ok := cCtx.keys[group][key]; ok {
+		return cCtx.clients[h], nil
+	}
+	h, err := cCtx.pick(group, key)
+	if err != nil {
+		return Partition{}, err
+	}
+	cCtx.groupKeys(group)[key] = h
 	cCtx.counter[h]++
+	cCtx.lastSeen[key] = time.Now()
+	cCtx.emit(Event{Type: EventAssign, Hostname: cCtx.clients[h].Hostname, Key: key})
 
-	return cCtx.clients[h]
+	return cCtx.clients[h], nil
 }
 
-func (cCtx *cacheCtx) Delete(hostname string) {
-	cCtx.delete(hostname)
-	if len(cCtx.clients) == 0 {
-		anyClients = false
+// Delete - removes client with all its incarnations, its keys are redistributed among remaining clients.
+// Epoch has to match the latest incarnation, 0 accepts any.
+func (cCtx *cacheCtx) Delete(hostname string, epoch uint64) error {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	if epoch != 0 {
+		latest := cCtx.clients[ClientID(hostname)].Epoch
+		if p, ok := cCtx.pending[hostname]; ok {
+			latest = p.Epoch
+		}
+		if epoch != latest {
+			return ErrStaleIncarnation
+		}
+	}
+	_, wasPending := cCtx.pending[hostname]
+	delete(cCtx.pending, hostname)
+	if _, ready := cCtx.clients[ClientID(hostname)]; ready {
+		cCtx.remove(ClientID(hostname))
+	} else if wasPending {
+		cCtx.emit(Event{Type: EventDelete, Hostname: hostname})
 	}
+
+	return nil
 }
 
-func (cCtx *cacheCtx) rebalance(hash uint32) {
-	cSum := 0
-	for k := range cCtx.counter {
-		cSum += cCtx.counter[k]
+// Verify - checks that epoch belongs to the ready or pending incarnation of the client, 0 accepts any
+func (cCtx *cacheCtx) Verify(hostname string, epoch uint64) error {
+	if epoch == 0 {
+		return nil
 	}
-	av := cSum / len(cCtx.clients)
-	for k, id := range cCtx.keys {
-		if av == 0 {
-			break
-		}
-		cCtx.keys[k] = hash
-		cCtx.counter[hash] += 1
-		cCtx.counter[id] -= 1
-		av--
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	if p, ok := cCtx.clients[ClientID(hostname)]; ok && p.Epoch == epoch {
+		return nil
+	}
+	if p, ok := cCtx.pending[hostname]; ok && p.Epoch == epoch {
+		return nil
 	}
+	return ErrStaleIncarnation
 }
 
-func (cCtx *cacheCtx) delete(hostname string) {
-	h := hash(hostname)
+// SetWeight - changes client weight and moves keys proportionally to the new weights
+func (cCtx *cacheCtx) SetWeight(hostname string, weight int) error {
+	if weight <= 0 {
+		return errors.New("weight has to be positive")
+	}
 	cCtx.mutex.Lock()
 	defer cCtx.mutex.Unlock()
+	h := ClientID(hostname)
+	p, ok := cCtx.clients[h]
+	if !ok {
+		return ErrClientNotFound
+	}
+	p.Weight = weight
+	cCtx.clients[h] = p
+	if _, gone := cCtx.absent[h]; gone {
+		// new weight is applied when client comes back
+		cCtx.emit(Event{Type: EventUpdate, Hostname: hostname, Partition: p})
+		return nil
+	}
+	strategy := cCtx.strategies[cCtx.groupOf(p)]
+	strategy.RemoveClient(h)
+	strategy.AddClient(h, hostname, weight)
+	cCtx.emit(Event{Type: EventUpdate, Hostname: hostname, Partition: p})
+	cCtx.rebalance(h)
+	cCtx.enforceRules()
+
+	return nil
+}
+
+func (cCtx *cacheCtx) rebalance(id ClientID) {
+	group := cCtx.groupOf(cCtx.clients[id])
+	moves := cCtx.strategies[group].Rebalance(id, cCtx.view(group))
+	for k, to := range moves {
+		// pinned keys stay on their targets
+		if ids, ok := cCtx.pinned(group, k); ok && !contains(ids, to) {
+			continue
+		}
+		// suspended clients keep their keys until they come back or are deleted
+		if _, gone := cCtx.absent[cCtx.keys[group][k]]; gone {
+			continue
+		}
+		cCtx.move(k, to)
+	}
+}
+
+// move - changes key owner, caller must hold write lock
+func (cCtx *cacheCtx) move(key string, to ClientID) {
+	group, _ := SplitGroupKey(key)
+	from := cCtx.keys[group][key]
+	cCtx.keys[group][key] = to
+	cCtx.counter[to] += 1
+	cCtx.counter[from] -= 1
+	cCtx.emit(Event{Type: EventAssign, Hostname: cCtx.clients[to].Hostname, Key: key, From: cCtx.clients[from].Hostname})
+}
+
+// view - strategies see only keys of their group, caller must hold the lock
+func (cCtx *cacheCtx) view(group string) View {
+	return View{Keys: cCtx.keys[group], Counter: cCtx.counter}
+}
+
+// remove - removes ready client and redistributes its keys within its group, caller must hold write lock
+func (cCtx *cacheCtx) remove(h ClientID) {
+	p, ok := cCtx.clients[h]
+	if !ok {
+		return
+	}
+	group := cCtx.groupOf(p)
 	delete(cCtx.clients, h)
-	for k, v := range cCtx.keys {
-		if v == h {
-			delete(cCtx.keys, k)
+	delete(cCtx.counter, h)
+	delete(cCtx.members[group], h)
+	delete(cCtx.absent, h)
+	cCtx.strategies[group].RemoveClient(h)
+
+	// keys are reassigned within the group before delete is emitted, so listeners see where every key went
+	keys := cCtx.keys[group]
+	for k, v := range keys {
+		if v != h {
+			continue
+		}
+		if len(cCtx.members[group]) == 0 {
+			delete(keys, k)
+			delete(cCtx.lastSeen, k)
+			delete(cCtx.marks, k)
+			continue
+		}
+		// existing keys can't be rejected, they go over quota when every client is full
+		to, err := cCtx.pick(group, k)
+		if err != nil {
+			to = cCtx.strategies[group].Assign(k, cCtx.view(group))
 		}
+		keys[k] = to
+		cCtx.counter[to]++
+		cCtx.emit(Event{Type: EventAssign, Hostname: cCtx.clients[to].Hostname, Key: k, From: p.Hostname})
 	}
+	cCtx.emit(Event{Type: EventDelete, Hostname: p.Hostname})
+}
+
+// join - registers ready client in its group, caller must hold write lock
+func (cCtx *cacheCtx) join(h ClientID, p Partition) {
+	group := cCtx.groupOf(p)
+	cCtx.clients[h] = p
+	cCtx.counter[h] = 0
+	if _, ok := cCtx.members[group]; !ok {
+		cCtx.members[group] = make(map[ClientID]struct{})
+	}
+	cCtx.members[group][h] = struct{}{}
+	strategy, ok := cCtx.strategies[group]
+	if !ok {
+		strategy = cCtx.newStrategy()
+		cCtx.strategies[group] = strategy
+	}
+	strategy.AddClient(h, p.Hostname, p.weight())
 }
 
 func hash(s string) uint32 {
@@ -178,3 +374,13 @@ func hash(s string) uint32 {
 	h.Write([]byte(s))
 	return h.Sum32()
 }
+
+// mix - splitmix64 finalizer, spreads similar inputs uniformly over all bits
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,281 @@
+package partition
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var strategies = []string{StrategyLeastLoaded, StrategyConsistentHash, StrategyRendezvous, StrategyRoundRobin}
+
+// cacheConfig - cache setup every sequence is run with
+type cacheConfig struct {
+	strategy string
+	slots    int
+	quota    Quota
+}
+
+func (cfg cacheConfig) String() string {
+	mode := "keys"
+	if cfg.slots > 0 {
+		mode = fmt.Sprintf("slots=%d", cfg.slots)
+	}
+	if cfg.quota.enabled() {
+		mode += fmt.Sprintf(",quota=%d/%g", cfg.quota.MaxKeys, cfg.quota.MaxFactor)
+	}
+	return cfg.strategy + "/" + mode
+}
+
+func cacheConfigs() []cacheConfig {
+	configs := make([]cacheConfig, 0)
+	for _, strategy := range strategies {
+		configs = append(configs,
+			cacheConfig{strategy: strategy},
+			cacheConfig{strategy: strategy, slots: 64},
+			cacheConfig{strategy: strategy, quota: Quota{MaxKeys: 8, Strict: true}},
+			cacheConfig{strategy: strategy, quota: Quota{MaxFactor: 1.5}},
+		)
+	}
+	return configs
+}
+
+// testCache - cache under test together with a snapshot replayed from its events
+type testCache struct {
+	*cacheCtx
+	t      *testing.T
+	replay Snapshot
+}
+
+func newTestCache(t *testing.T, cfg cacheConfig) *testCache {
+	t.Helper()
+	if _, err := NewStrategy(cfg.strategy, 16); err != nil {
+		t.Fatal(err)
+	}
+	newStrategy := func() AssignmentStrategy {
+		s, _ := NewStrategy(cfg.strategy, 16)
+		return s
+	}
+	tc := &testCache{
+		cacheCtx: NewCache(newStrategy, cfg.slots, cfg.quota, "group").(*cacheCtx),
+		t:        t,
+		replay:   NewSnapshot(),
+	}
+	tc.Subscribe(tc.replay.Apply)
+	return tc
+}
+
+// check - cache has to be consistent and its events have to describe its state after every step
+func (tc *testCache) check(step string) {
+	tc.t.Helper()
+	if err := tc.Check(); err != nil {
+		tc.t.Fatalf("after %s: %v", step, err)
+	}
+	s := tc.Snapshot()
+	for name, equal := range map[string]bool{
+		"clients":   reflect.DeepEqual(s.Clients, tc.replay.Clients),
+		"keys":      reflect.DeepEqual(s.Keys, tc.replay.Keys),
+		"suspended": reflect.DeepEqual(s.Suspended, tc.replay.Suspended),
+		// replayed rules become empty slice once every rule is deleted
+		"rules": len(s.Rules) == 0 && len(tc.replay.Rules) == 0 || reflect.DeepEqual(s.Rules, tc.replay.Rules),
+	} {
+		if !equal {
+			tc.t.Fatalf("after %s: %s replayed from events differ from cache", step, name)
+		}
+	}
+}
+
+func (tc *testCache) bind(hostname string, weight int, labels map[string]string) {
+	tc.t.Helper()
+	epoch := tc.AddPending(hostname, Partition{RoutingKey: "rk-" + hostname, Weight: weight, Labels: labels})
+	tc.check("pending " + hostname)
+	if err := tc.AddReady(hostname, epoch); err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.check("ready " + hostname)
+}
+
+func (tc *testCache) assign(group, key string) {
+	tc.t.Helper()
+	// cache hashes key into its slot itself, the same as for keys passed by sender
+	_, err := tc.AssignToFreePartition(group, key)
+	if err != nil && !errors.Is(err, ErrQuotaExceeded) && !errors.Is(err, ErrClientNotFound) {
+		tc.t.Fatal(err)
+	}
+	tc.check("assign " + GroupKey(group, key))
+}
+
+func TestCacheSequence(t *testing.T) {
+	for _, cfg := range cacheConfigs() {
+		t.Run(cfg.String(), func(t *testing.T) {
+			tc := newTestCache(t, cfg)
+			premium := map[string]string{"group": "premium"}
+			tc.bind("a", 1, nil)
+			tc.bind("b", 2, map[string]string{"zone": "eu"})
+			tc.bind("p1", 1, premium)
+			for i := 0; i < 40; i++ {
+				tc.assign("", fmt.Sprintf("key-%d", i))
+				tc.assign("premium", fmt.Sprintf("key-%d", i))
+			}
+
+			tc.bind("c", 1, nil)
+			tc.bind("p2", 1, premium)
+			// restarted client keeps its keys, client which changed its group loses them
+			tc.bind("a", 1, nil)
+			tc.bind("p2", 1, nil)
+
+			if err := tc.SetWeight("c", 3); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("weight c")
+
+			if err := tc.PutRule(Rule{ID: "r1", Match: MatchPrefix, Pattern: "key-1", Hostname: "c"}); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("rule r1")
+			if err := tc.PutRule(Rule{ID: "r2", Match: MatchRegex, Pattern: "^key-2", Label: "zone=eu"}); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("rule r2")
+			for i := 40; i < 60; i++ {
+				tc.assign("", fmt.Sprintf("key-%d", i))
+			}
+
+			if err := tc.Suspend("b"); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("suspend b")
+			for i := 60; i < 80; i++ {
+				tc.assign("", fmt.Sprintf("key-%d", i))
+			}
+			if err := tc.Resume("b"); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("resume b")
+			if err := tc.Suspend("c"); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("suspend c")
+			// suspended client comes back with a new incarnation
+			tc.bind("c", 1, nil)
+
+			if err := tc.DeleteRule("r1"); err != nil {
+				t.Fatal(err)
+			}
+			tc.check("delete rule r1")
+			if _, _, err := tc.Isolate(tc.anyKey(""), ""); err != nil && !errors.Is(err, ErrKeyNotAssigned) {
+				t.Fatal(err)
+			}
+			tc.check("isolate")
+
+			drained := func(Partition) bool { return true }
+			tc.Evict(0, drained)
+			tc.check("first eviction")
+			tc.Evict(0, drained)
+			tc.check("second eviction")
+			if cfg.slots == 0 && len(tc.Snapshot().Keys) != 0 {
+				t.Fatalf("idle keys were not evicted: %v", tc.Snapshot().Keys)
+			}
+
+			for _, hostname := range []string{"a", "p1", "b"} {
+				if err := tc.Delete(hostname, 0); err != nil {
+					t.Fatal(err)
+				}
+				tc.check("delete " + hostname)
+			}
+			tc.assign("premium", "key-0")
+			tc.assign("", "key-0")
+			for _, hostname := range []string{"c", "p2"} {
+				if err := tc.Delete(hostname, 0); err != nil {
+					t.Fatal(err)
+				}
+				tc.check("delete " + hostname)
+			}
+			if s := tc.Snapshot(); len(s.Clients) != 0 || len(s.Keys) != 0 {
+				t.Fatalf("cache is not empty after every client left: %+v", s)
+			}
+		})
+	}
+}
+
+func TestCacheRandomized(t *testing.T) {
+	hostnames := []string{"a", "b", "c", "d", "e", "f"}
+	groups := []string{"", "premium"}
+	for _, cfg := range cacheConfigs() {
+		t.Run(cfg.String(), func(t *testing.T) {
+			seed := time.Now().UnixNano()
+			rnd := rand.New(rand.NewSource(seed))
+			t.Logf("seed %d", seed)
+			tc := newTestCache(t, cfg)
+			for i := 0; i < 1500; i++ {
+				hostname := hostnames[rnd.Intn(len(hostnames))]
+				group := groups[rnd.Intn(len(groups))]
+				switch op := rnd.Intn(100); {
+				case op < 12:
+					labels := map[string]string{"zone": fmt.Sprint(rnd.Intn(2))}
+					if group != "" {
+						labels["group"] = group
+					}
+					tc.bind(hostname, 1+rnd.Intn(3), labels)
+				case op < 15:
+					// pending incarnation which never becomes ready
+					tc.AddPending(hostname, Partition{RoutingKey: "rk-" + hostname})
+					tc.check("pending " + hostname)
+				case op < 20:
+					_ = tc.Delete(hostname, 0)
+					tc.check("delete " + hostname)
+				case op < 24:
+					_ = tc.Suspend(hostname)
+					tc.check("suspend " + hostname)
+				case op < 28:
+					_ = tc.Resume(hostname)
+					tc.check("resume " + hostname)
+				case op < 31:
+					_ = tc.SetWeight(hostname, 1+rnd.Intn(4))
+					tc.check("weight " + hostname)
+				case op < 34:
+					rule := Rule{ID: fmt.Sprint("r", rnd.Intn(3)), Match: MatchPrefix, Pattern: fmt.Sprint("key-", rnd.Intn(10))}
+					if rnd.Intn(2) == 0 {
+						rule.Hostname = hostname
+					} else {
+						rule.Label = fmt.Sprint("zone=", rnd.Intn(2))
+					}
+					if err := tc.PutRule(rule); err != nil {
+						t.Fatal(err)
+					}
+					tc.check("rule " + rule.ID)
+				case op < 36:
+					_ = tc.DeleteRule(fmt.Sprint("r", rnd.Intn(3)))
+					tc.check("delete rule")
+				case op < 38:
+					if key := tc.anyKey(group); key != "" {
+						_, _, _ = tc.Isolate(key, "")
+					}
+					tc.check("isolate")
+				case op < 40:
+					if key := tc.anyKey(group); key != "" {
+						_ = tc.Move(key, hostname)
+					}
+					tc.check("move")
+				case op < 42:
+					tc.Evict(0, func(Partition) bool { return rnd.Intn(2) == 0 })
+					tc.check("eviction")
+				default:
+					tc.assign(group, fmt.Sprint("key-", rnd.Intn(200)))
+				}
+			}
+		})
+	}
+}
+
+// anyKey - returns some assigned key of the group, empty when group has none
+func (tc *testCache) anyKey(group string) string {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+	for k := range tc.keys[group] {
+		return k
+	}
+	return ""
+}
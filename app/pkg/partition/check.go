@@ -0,0 +1,72 @@
+package partition
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Check - verifies internal consistency of the cache, returns all found violations
+func (cCtx *cacheCtx) Check() error {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	cCtx.seenMutex.Lock()
+	defer cCtx.seenMutex.Unlock()
+
+	violations := make([]error, 0)
+	owned := make(map[ClientID]int, len(cCtx.clients))
+	for group, keys := range cCtx.keys {
+		for k, id := range keys {
+			p, ok := cCtx.clients[id]
+			if !ok {
+				violations = append(violations, fmt.Errorf("key %q assigned to unknown client %q", k, id))
+				continue
+			}
+			if g, _ := SplitGroupKey(k); g != group || group != cCtx.groupOf(p) {
+				violations = append(violations, fmt.Errorf("key %q of group %q assigned to client %s of group %q", k, group, p.Hostname, cCtx.groupOf(p)))
+			}
+			owned[id]++
+		}
+	}
+	for id, c := range cCtx.counter {
+		p, ok := cCtx.clients[id]
+		if !ok {
+			violations = append(violations, fmt.Errorf("counter of unknown client %q", id))
+			continue
+		}
+		if c != owned[id] {
+			violations = append(violations, fmt.Errorf("counter of client %s is %d, but it owns %d keys", p.Hostname, c, owned[id]))
+		}
+	}
+	for id, p := range cCtx.clients {
+		if _, ok := cCtx.counter[id]; !ok {
+			violations = append(violations, fmt.Errorf("client %s has no counter", p.Hostname))
+		}
+		if ClientID(p.Hostname) != id {
+			violations = append(violations, fmt.Errorf("client %s stored under foreign id %q", p.Hostname, id))
+		}
+		_, member := cCtx.members[cCtx.groupOf(p)][id]
+		if _, gone := cCtx.absent[id]; gone == member {
+			violations = append(violations, fmt.Errorf("client %s has to be either member of its group %q or suspended", p.Hostname, cCtx.groupOf(p)))
+		}
+		if p.Epoch > cCtx.epoch {
+			violations = append(violations, fmt.Errorf("client %s has epoch %d from the future", p.Hostname, p.Epoch))
+		}
+	}
+	for hostname, p := range cCtx.pending {
+		if p.Epoch > cCtx.epoch {
+			violations = append(violations, fmt.Errorf("pending client %s has epoch %d from the future", hostname, p.Epoch))
+		}
+	}
+	for k := range cCtx.lastSeen {
+		if _, ok := cCtx.owner(k); !ok {
+			violations = append(violations, fmt.Errorf("last seen time of unassigned key %q", k))
+		}
+	}
+	for k := range cCtx.marks {
+		if _, ok := cCtx.owner(k); !ok {
+			violations = append(violations, fmt.Errorf("eviction mark of unassigned key %q", k))
+		}
+	}
+
+	return errors.Join(violations...)
+}
@@ -0,0 +1,197 @@
+package partition
+
+import "time"
+
+// EventType - kind of cache mutation
+type EventType string
+
+const (
+	EventPending    EventType = "pending"
+	EventReady      EventType = "ready"
+	EventUpdate     EventType = "update"
+	EventDelete     EventType = "delete"
+	EventSuspend    EventType = "suspend"
+	EventResume     EventType = "resume"
+	EventAssign     EventType = "assign"
+	EventUnassign   EventType = "unassign"
+	EventRule       EventType = "rule"
+	EventRuleDelete EventType = "rule-delete"
+)
+
+// Event - cache mutation. Assign carries the previous owner in From when key was moved
+type Event struct {
+	Type      EventType `json:"type"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Partition Partition `json:"partition"`
+	Key       string    `json:"key,omitempty"`
+	From      string    `json:"from,omitempty"`
+	Rule      *Rule     `json:"rule,omitempty"`
+}
+
+// Listener - receives cache mutations, it's called under the cache lock so it must not call the cache back
+type Listener func(e Event)
+
+// Snapshot - copy of the cache state, clients and keys are identified by hostname.
+// Epoch is the highest incarnation epoch ever issued, so epochs are not reused after restart.
+// Suspended clients are listed in Clients as well
+type Snapshot struct {
+	Clients   map[string]Partition `json:"clients"`
+	Pending   map[string]Partition `json:"pending"`
+	Keys      map[string]string    `json:"keys"`
+	Suspended map[string]bool      `json:"suspended,omitempty"`
+	Rules     []Rule               `json:"rules,omitempty"`
+	Epoch     uint64               `json:"epoch,omitempty"`
+}
+
+// NewSnapshot - creates empty snapshot
+func NewSnapshot() Snapshot {
+	return Snapshot{
+		Clients:   make(map[string]Partition),
+		Pending:   make(map[string]Partition),
+		Keys:      make(map[string]string),
+		Suspended: make(map[string]bool),
+	}
+}
+
+// Apply - applies cache mutation on snapshot, used when replaying persisted events
+func (s *Snapshot) Apply(e Event) {
+	switch e.Type {
+	case EventPending:
+		s.Pending[e.Hostname] = e.Partition
+		s.Epoch = max(s.Epoch, e.Partition.Epoch)
+	case EventReady:
+		s.Clients[e.Hostname] = e.Partition
+		delete(s.Pending, e.Hostname)
+		delete(s.Suspended, e.Hostname)
+	case EventUpdate:
+		s.Clients[e.Hostname] = e.Partition
+	case EventDelete:
+		delete(s.Clients, e.Hostname)
+		delete(s.Pending, e.Hostname)
+		delete(s.Suspended, e.Hostname)
+		for k, v := range s.Keys {
+			if v == e.Hostname {
+				delete(s.Keys, k)
+			}
+		}
+	case EventSuspend:
+		if s.Suspended == nil {
+			s.Suspended = make(map[string]bool)
+		}
+		s.Suspended[e.Hostname] = true
+	case EventResume:
+		delete(s.Suspended, e.Hostname)
+	case EventAssign:
+		s.Keys[e.Key] = e.Hostname
+	case EventUnassign:
+		delete(s.Keys, e.Key)
+	case EventRule:
+		for i := range s.Rules {
+			if s.Rules[i].ID == e.Rule.ID {
+				s.Rules[i] = *e.Rule
+				return
+			}
+		}
+		s.Rules = append(s.Rules, *e.Rule)
+	case EventRuleDelete:
+		for i := range s.Rules {
+			if s.Rules[i].ID == e.Rule.ID {
+				s.Rules = append(s.Rules[:i], s.Rules[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (cCtx *cacheCtx) Subscribe(l Listener) {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	cCtx.listeners = append(cCtx.listeners, l)
+}
+
+// emit - notifies listeners, caller must hold write lock
+func (cCtx *cacheCtx) emit(e Event) {
+	for _, l := range cCtx.listeners {
+		l(e)
+	}
+}
+
+func (cCtx *cacheCtx) Snapshot() Snapshot {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	return cCtx.snapshot()
+}
+
+// Capture - passes snapshot to fn while no event can be emitted, so whatever fn queues
+// is ordered exactly between events included in the snapshot and the following ones
+func (cCtx *cacheCtx) Capture(fn func(s Snapshot)) {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	fn(cCtx.snapshot())
+}
+
+// snapshot - caller must hold the lock
+func (cCtx *cacheCtx) snapshot() Snapshot {
+	s := NewSnapshot()
+	s.Epoch = cCtx.epoch
+	for _, p := range cCtx.clients {
+		s.Clients[p.Hostname] = p
+	}
+	for hostname, p := range cCtx.pending {
+		s.Pending[hostname] = p
+	}
+	for id := range cCtx.absent {
+		s.Suspended[cCtx.clients[id].Hostname] = true
+	}
+	for _, keys := range cCtx.keys {
+		for k, id := range keys {
+			s.Keys[k] = cCtx.clients[id].Hostname
+		}
+	}
+	for i := range cCtx.rules {
+		s.Rules = append(s.Rules, cCtx.rules[i].Rule)
+	}
+	return s
+}
+
+// Restore - loads snapshot into the cache, keys of unknown clients and invalid rules are skipped
+func (cCtx *cacheCtx) Restore(s Snapshot) {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	for i := range s.Rules {
+		if cr, err := compileRule(s.Rules[i]); err == nil {
+			cCtx.putRule(cr)
+		}
+	}
+	cCtx.epoch = max(cCtx.epoch, s.Epoch)
+	for hostname, p := range s.Pending {
+		p.Hostname = hostname
+		cCtx.pending[hostname] = p
+		cCtx.epoch = max(cCtx.epoch, p.Epoch)
+	}
+	for hostname, p := range s.Clients {
+		p.Hostname = hostname
+		cCtx.join(ClientID(hostname), p)
+		cCtx.epoch = max(cCtx.epoch, p.Epoch)
+	}
+	for hostname := range s.Suspended {
+		if h := ClientID(hostname); cCtx.clients[h].Hostname == hostname {
+			cCtx.suspend(h)
+		}
+	}
+	now := time.Now()
+	for k, hostname := range s.Keys {
+		h := ClientID(hostname)
+		p, ok := cCtx.clients[h]
+		if !ok {
+			continue
+		}
+		group, _ := SplitGroupKey(k)
+		if group != cCtx.groupOf(p) {
+			continue
+		}
+		cCtx.groupKeys(group)[k] = h
+		cCtx.counter[h]++
+		cCtx.lastSeen[k] = now
+	}
+}
@@ -0,0 +1,65 @@
+package partition
+
+import "time"
+
+// Evict - removes keys which were not seen for idleAfter and whose partition queue is drained.
+// Key is evicted by the second sweep which finds it idle and drained, so messages still in flight
+// during the first sweep (delivered but not acked yet) have a full check interval to be processed.
+// Drained is called without holding the cache lock. Returns number of evicted keys, slots are never evicted.
+func (cCtx *cacheCtx) Evict(idleAfter time.Duration, drained func(p Partition) bool) int {
+	// number of slots is constant, there is nothing to evict
+	if cCtx.slots > 0 {
+		return 0
+	}
+	threshold := time.Now().Add(-idleAfter)
+
+	cCtx.mutex.RLock()
+	owners := make(map[ClientID]Partition)
+	cCtx.seenMutex.Lock()
+	for _, keys := range cCtx.keys {
+		for k, id := range keys {
+			if cCtx.lastSeen[k].Before(threshold) {
+				owners[id] = cCtx.clients[id]
+			}
+		}
+	}
+	cCtx.seenMutex.Unlock()
+	cCtx.mutex.RUnlock()
+
+	isDrained := make(map[ClientID]bool, len(owners))
+	for id, p := range owners {
+		isDrained[id] = drained(p)
+	}
+
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	evicted := 0
+	for _, keys := range cCtx.keys {
+		for k, id := range keys {
+			seen := cCtx.lastSeen[k]
+			if !seen.Before(threshold) || !isDrained[id] {
+				delete(cCtx.marks, k)
+				continue
+			}
+			if mark, marked := cCtx.marks[k]; marked && mark.Equal(seen) {
+				delete(keys, k)
+				delete(cCtx.lastSeen, k)
+				delete(cCtx.marks, k)
+				cCtx.counter[id]--
+				cCtx.emit(Event{Type: EventUnassign, Hostname: cCtx.clients[id].Hostname, Key: k})
+				evicted++
+				continue
+			}
+			cCtx.marks[k] = seen
+		}
+	}
+
+	return evicted
+}
+
+// touch - records key usage, caller must hold at least read lock
+func (cCtx *cacheCtx) touch(key string) {
+	cCtx.seenMutex.Lock()
+	cCtx.lastSeen[key] = time.Now()
+	cCtx.seenMutex.Unlock()
+}
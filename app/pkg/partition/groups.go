@@ -0,0 +1,47 @@
+package partition
+
+import "strings"
+
+// groupSeparator - separates group from key in keys tracked by the cache, keys of default group are kept as they are
+const groupSeparator = "\x1f"
+
+// GroupKey - key under which assignment of key in group is tracked
+func GroupKey(group, key string) string {
+	if group == "" {
+		return key
+	}
+	return group + groupSeparator + key
+}
+
+// SplitGroupKey - reverses GroupKey
+func SplitGroupKey(k string) (string, string) {
+	if group, key, ok := strings.Cut(k, groupSeparator); ok {
+		return group, key
+	}
+	return "", k
+}
+
+// groupKeys - key map of the group, created on first use. Caller must hold write lock
+func (cCtx *cacheCtx) groupKeys(group string) map[string]ClientID {
+	keys, ok := cCtx.keys[group]
+	if !ok {
+		keys = make(map[string]ClientID)
+		cCtx.keys[group] = keys
+	}
+	return keys
+}
+
+// owner - owner of the group key, caller must hold the lock
+func (cCtx *cacheCtx) owner(k string) (ClientID, bool) {
+	group, _ := SplitGroupKey(k)
+	id, ok := cCtx.keys[group][k]
+	return id, ok
+}
+
+// groupOf - group of the client is the value of its group label
+func (cCtx *cacheCtx) groupOf(p Partition) string {
+	if cCtx.groupLabel == "" {
+		return ""
+	}
+	return p.Labels[cCtx.groupLabel]
+}
@@ -0,0 +1,60 @@
+package partition
+
+import "errors"
+
+// HotRulePrefix - prefix of ids of affinity rules created by hot key isolation
+const HotRulePrefix = "hot:"
+
+var ErrKeyNotAssigned = errors.New("key is not assigned")
+
+// HotRuleID - id of affinity rule pinning hot key of the group
+func HotRuleID(group, key string) string {
+	if group == "" {
+		return HotRulePrefix + key
+	}
+	return HotRulePrefix + group + ":" + key
+}
+
+// Isolate - pins assigned key (GroupKey) with an exact affinity rule to the least loaded client of its group
+// other than the current owner, with label (key=value) only to clients having it. Only the isolated key moves.
+// Returns owner and whether key was isolated now, keys already pinned by a rule are left alone.
+func (cCtx *cacheCtx) Isolate(key, label string) (string, bool, error) {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	group, raw := SplitGroupKey(key)
+	owner, ok := cCtx.keys[group][key]
+	if !ok {
+		return "", false, ErrKeyNotAssigned
+	}
+	if _, pinned := cCtx.pinned(group, key); pinned {
+		return cCtx.clients[owner].Hostname, false, nil
+	}
+
+	selector := compiledRule{Rule: Rule{Label: label}}
+	candidates := make([]ClientID, 0)
+	for id := range cCtx.members[group] {
+		if label != "" && !selector.targets(cCtx.clients[id]) {
+			continue
+		}
+		if label == "" && id == owner && len(cCtx.members[group]) > 1 {
+			continue
+		}
+		candidates = append(candidates, id)
+	}
+	if len(candidates) == 0 {
+		return "", false, ErrClientNotFound
+	}
+	to := cCtx.leastLoadedOf(candidates)
+	if contains(candidates, owner) {
+		to = owner
+	}
+
+	r := Rule{ID: HotRuleID(group, raw), Match: MatchExact, Pattern: raw, Hostname: cCtx.clients[to].Hostname}
+	cCtx.putRule(compiledRule{Rule: r})
+	cCtx.emit(Event{Type: EventRule, Rule: &r})
+	if to != owner {
+		cCtx.move(key, to)
+	}
+
+	return r.Hostname, true, nil
+}
@@ -0,0 +1,37 @@
+package partition
+
+import "math"
+
+// GetClient - returns ready client by hostname, regardless of its group
+func (cCtx *cacheCtx) GetClient(hostname string) (Partition, error) {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	p, ok := cCtx.clients[ClientID(hostname)]
+	if !ok {
+		return Partition{}, ErrClientNotFound
+	}
+	return p, nil
+}
+
+// GetHashedPartition - returns client of the group picked by weighted rendezvous hashing of seed, the same seed
+// lands on the same client while group members don't change. Suspended clients are skipped, no key is assigned.
+func (cCtx *cacheCtx) GetHashedPartition(group, seed string) (Partition, error) {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	sh := hash(seed)
+	best := math.Inf(-1)
+	var h ClientID
+	for id := range cCtx.members[group] {
+		if _, ok := cCtx.absent[id]; ok {
+			continue
+		}
+		if sc := score(sh, id, cCtx.clients[id].weight()); sc > best || (sc == best && id < h) {
+			best = sc
+			h = id
+		}
+	}
+	if h == "" {
+		return Partition{}, ErrClientNotFound
+	}
+	return cCtx.clients[h], nil
+}
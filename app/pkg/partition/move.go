@@ -0,0 +1,42 @@
+package partition
+
+import "errors"
+
+var ErrKeyPinned = errors.New("key is pinned by affinity rule")
+
+// Move - moves assigned key (GroupKey) to another client of its group, pinned keys can't be moved.
+// Hash based strategies return the key to its hash owner on the next rebalance.
+func (cCtx *cacheCtx) Move(key, hostname string) error {
+	cCtx.mutex.Lock()
+	defer cCtx.mutex.Unlock()
+	group, _ := SplitGroupKey(key)
+	owner, ok := cCtx.keys[group][key]
+	if !ok {
+		return ErrKeyNotAssigned
+	}
+	to := ClientID(hostname)
+	if _, ok = cCtx.members[group][to]; !ok {
+		return ErrClientNotFound
+	}
+	if _, pinned := cCtx.pinned(group, key); pinned {
+		return ErrKeyPinned
+	}
+	if owner != to {
+		cCtx.move(key, to)
+	}
+
+	return nil
+}
+
+// Groups - returns ready clients by their group
+func (cCtx *cacheCtx) Groups() map[string][]Partition {
+	cCtx.mutex.RLock()
+	defer cCtx.mutex.RUnlock()
+	groups := make(map[string][]Partition, len(cCtx.members))
+	for group, ids := range cCtx.members {
+		for id := range ids {
+			groups[group] = append(groups[group], cCtx.clients[id])
+		}
+	}
+	return groups
+}
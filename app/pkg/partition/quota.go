@@ -0,0 +1,76 @@
+package partition
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrQuotaExceeded = errors.New("every client reached its key quota")
+
+// Quota - limits number of keys owned by a single client
+type Quota struct {
+	// MaxKeys - absolute limit of keys per client, 0 disables the limit
+	MaxKeys int
+	// MaxFactor - limit relative to client's weighted share of all keys, e.g. 1.5, 0 disables the limit
+	MaxFactor float64
+	// Strict - when every client is at quota, new key is rejected with ErrQuotaExceeded instead of assigned anyway
+	Strict bool
+}
+
+func (q Quota) enabled() bool {
+	return q.MaxKeys > 0 || q.MaxFactor > 0
+}
+
+// limit - number of keys client can own, relative limit is computed within client's group. Caller must hold the lock
+func (cCtx *cacheCtx) limit(group string, id ClientID) int {
+	l := math.MaxInt
+	if cCtx.quota.MaxKeys > 0 {
+		l = cCtx.quota.MaxKeys
+	}
+	if cCtx.quota.MaxFactor > 0 {
+		cSum, wSum := 0, 0
+		for m := range cCtx.members[group] {
+			cSum += cCtx.counter[m]
+			wSum += cCtx.clients[m].weight()
+		}
+		// counts the key being assigned, so the very first key fits as well
+		share := float64(cSum+1) * float64(cCtx.clients[id].weight()) / float64(wSum)
+		l = min(l, int(math.Ceil(cCtx.quota.MaxFactor*share)))
+	}
+	return l
+}
+
+// pick - returns owner for a new key of the group, pinned keys go to their targets. Strategy choice is overridden
+// by the least loaded client under quota when the chosen one is full. Caller must hold the lock.
+func (cCtx *cacheCtx) pick(group, key string) (ClientID, error) {
+	// pinned keys ignore both strategy and quota
+	if ids, ok := cCtx.pinned(group, key); ok {
+		return cCtx.leastLoadedOf(ids), nil
+	}
+	h := cCtx.strategies[group].Assign(key, cCtx.view(group))
+	if !cCtx.quota.enabled() || cCtx.counter[h] < cCtx.limit(group, h) {
+		return h, nil
+	}
+
+	found := false
+	var best ClientID
+	for id := range cCtx.members[group] {
+		p := cCtx.clients[id]
+		if cCtx.counter[id] >= cCtx.limit(group, id) {
+			continue
+		}
+		// counter/weight compared without floating point
+		if !found || cCtx.counter[id]*cCtx.clients[best].weight() < cCtx.counter[best]*p.weight() {
+			best = id
+			found = true
+		}
+	}
+	if found {
+		return best, nil
+	}
+	if !cCtx.quota.Strict {
+		return h, nil
+	}
+
+	return "", ErrQuotaExceeded
+}
@@ -0,0 +1,53 @@
+package partition
+
+import "math"
+
+// rendezvous - highest random weight hashing, every client scores every key and the highest score wins.
+// Join or leave moves only keys won or lost by that client, no ring state is needed.
+// Scores are weighted logarithmically, so client's share of keys is proportional to its weight.
+type rendezvous struct {
+	clients map[ClientID]int
+}
+
+func newRendezvous() *rendezvous {
+	return &rendezvous{clients: make(map[ClientID]int)}
+}
+
+func (s *rendezvous) Name() string {
+	return StrategyRendezvous
+}
+
+func (s *rendezvous) AddClient(id ClientID, _ string, weight int) {
+	s.clients[id] = weight
+}
+
+func (s *rendezvous) RemoveClient(id ClientID) {
+	delete(s.clients, id)
+}
+
+func (s *rendezvous) Assign(key string, _ View) ClientID {
+	kh := hash(key)
+	best := math.Inf(-1)
+	var h ClientID
+	for id, w := range s.clients {
+		if sc := score(kh, id, w); sc > best || (sc == best && id < h) {
+			best = sc
+			h = id
+		}
+	}
+	return h
+}
+
+func (s *rendezvous) Rebalance(_ ClientID, view View) map[string]ClientID {
+	return remap(view, func(key string) ClientID {
+		return s.Assign(key, view)
+	})
+}
+
+// score - mixes key hash with client id (splitmix64 finalizer) and weights the result
+func score(keyHash uint32, id ClientID, weight int) float64 {
+	x := mix(uint64(keyHash)<<32 | uint64(hash(string(id))))
+	// uniform value from open interval (0, 1)
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(u)
+}
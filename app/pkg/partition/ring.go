@@ -0,0 +1,111 @@
+package partition
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes - number of points on the ring per client when nothing else is configured
+const DefaultVirtualNodes = 128
+
+// hashRing - consistent hashing ring, every client owns vNodes points per unit of its weight
+type hashRing struct {
+	vNodes int
+	points []uint32
+	owners map[uint32]ClientID
+}
+
+func newHashRing(vNodes int) *hashRing {
+	if vNodes <= 0 {
+		vNodes = DefaultVirtualNodes
+	}
+	return &hashRing{
+		vNodes: vNodes,
+		points: make([]uint32, 0),
+		owners: make(map[uint32]ClientID),
+	}
+}
+
+func (r *hashRing) add(id ClientID, hostname string, weight int) {
+	for i := 0; i < r.vNodes*weight; i++ {
+		p := ringHash(hostname + "#" + strconv.Itoa(i))
+		// on point collision the lower client id keeps the point, so result does not depend on join order
+		if owner, taken := r.owners[p]; taken && owner < id {
+			continue
+		}
+		r.owners[p] = id
+	}
+	r.rebuild()
+}
+
+func (r *hashRing) remove(id ClientID) {
+	for p, owner := range r.owners {
+		if owner == id {
+			delete(r.owners, p)
+		}
+	}
+	r.rebuild()
+}
+
+func (r *hashRing) rebuild() {
+	r.points = r.points[:0]
+	for p := range r.owners {
+		r.points = append(r.points, p)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// lookup - returns owner of the first point clockwise from key hash
+func (r *hashRing) lookup(key string) (ClientID, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+// ringHash - position on the ring, plain FNV of hostname#i points which differ in last characters clusters them
+func ringHash(s string) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return uint32(mix(h.Sum64()) >> 32)
+}
+
+// consistentHash - key owner is derived from the hash ring,
+// join or leave of a client moves only about 1/N of keys, weight change moves keys proportionally
+type consistentHash struct {
+	ring *hashRing
+}
+
+func newConsistentHash(vNodes int) *consistentHash {
+	return &consistentHash{ring: newHashRing(vNodes)}
+}
+
+func (s *consistentHash) Name() string {
+	return StrategyConsistentHash
+}
+
+func (s *consistentHash) AddClient(id ClientID, hostname string, weight int) {
+	s.ring.add(id, hostname, weight)
+}
+
+func (s *consistentHash) RemoveClient(id ClientID) {
+	s.ring.remove(id)
+}
+
+func (s *consistentHash) Assign(key string, _ View) ClientID {
+	h, _ := s.ring.lookup(key)
+	return h
+}
+
+func (s *consistentHash) Rebalance(_ ClientID, view View) map[string]ClientID {
+	return remap(view, func(key string) ClientID {
+		h, _ := s.ring.lookup(key)
+		return h
+	})
+}
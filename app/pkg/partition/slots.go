@@ -0,0 +1,31 @@
+package partition
+
+import "strconv"
+
+const slotKeyPrefix = "slot:"
+
+// SlotOf - maps partition key on one of the logical slots
+func SlotOf(key string, slots int) int {
+	return int(hash(key) % uint32(slots))
+}
+
+// SlotKey - key under which slot assignment is tracked in the cache
+func SlotKey(slot int) string {
+	return slotKeyPrefix + strconv.Itoa(slot)
+}
+
+// Slot - returns slot of passed key, false when cache tracks keys individually
+func (cCtx *cacheCtx) Slot(key string) (int, bool) {
+	if cCtx.slots <= 0 || key == "" {
+		return 0, false
+	}
+	return SlotOf(key, cCtx.slots), true
+}
+
+// assignmentKey - in slot mode slots are assigned to clients instead of keys
+func (cCtx *cacheCtx) assignmentKey(key string) string {
+	if slot, ok := cCtx.Slot(key); ok {
+		return SlotKey(slot)
+	}
+	return key
+}
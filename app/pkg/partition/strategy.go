@@ -0,0 +1,197 @@
+package partition
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Strategy names, used in config
+const (
+	StrategyLeastLoaded    = "least-loaded"
+	StrategyConsistentHash = "consistent-hash"
+	StrategyRendezvous     = "rendezvous"
+	StrategyRoundRobin     = "round-robin"
+)
+
+// View - read only view on current key assignment, passed to strategies
+type View struct {
+	Keys    map[string]ClientID
+	Counter map[ClientID]int
+}
+
+// AssignmentStrategy - decides which client owns a partition key.
+// Strategies are called by the cache under its lock, so implementations don't need to be goroutine safe.
+type AssignmentStrategy interface {
+	// Name - name of the strategy as used in config
+	Name() string
+	// AddClient - registers ready client, also called again with new weight after RemoveClient on weight change
+	AddClient(id ClientID, hostname string, weight int)
+	// RemoveClient - unregisters client
+	RemoveClient(id ClientID)
+	// Assign - picks owner for a key which is not assigned yet
+	Assign(key string, view View) ClientID
+	// Rebalance - called after client joined or changed weight, returns keys which should be moved with their new owner
+	Rebalance(changed ClientID, view View) map[string]ClientID
+}
+
+// NewStrategy - creates assignment strategy by its name
+func NewStrategy(name string, vNodes int) (AssignmentStrategy, error) {
+	switch name {
+	case StrategyLeastLoaded, "":
+		return newLeastLoaded(), nil
+	case StrategyConsistentHash:
+		return newConsistentHash(vNodes), nil
+	case StrategyRendezvous:
+		return newRendezvous(), nil
+	case StrategyRoundRobin:
+		return newRoundRobin(), nil
+	}
+
+	return nil, fmt.Errorf("unknown assignment strategy: %s", name)
+}
+
+// remap - moves every key whose owner differs from the one returned by lookup, used by hash based strategies
+func remap(view View, lookup func(key string) ClientID) map[string]ClientID {
+	moves := make(map[string]ClientID)
+	for k, id := range view.Keys {
+		if h := lookup(k); h != id {
+			moves[k] = h
+		}
+	}
+	return moves
+}
+
+// leastLoaded - new key goes to the client with the smallest number of keys per unit of weight,
+// rebalance moves keys from clients above their weighted share to clients below it
+type leastLoaded struct {
+	clients map[ClientID]int
+}
+
+func newLeastLoaded() *leastLoaded {
+	return &leastLoaded{clients: make(map[ClientID]int)}
+}
+
+func (s *leastLoaded) Name() string {
+	return StrategyLeastLoaded
+}
+
+func (s *leastLoaded) AddClient(id ClientID, _ string, weight int) {
+	s.clients[id] = weight
+}
+
+func (s *leastLoaded) RemoveClient(id ClientID) {
+	delete(s.clients, id)
+}
+
+func (s *leastLoaded) Assign(_ string, view View) ClientID {
+	c, w := -1, 1
+	var h ClientID
+	for id, weight := range s.clients {
+		// v/weight < c/w without floating point
+		v := view.Counter[id]
+		if c < 0 || v*w < c*weight || (v*w == c*weight && id < h) {
+			c, w = v, weight
+			h = id
+		}
+	}
+	return h
+}
+
+func (s *leastLoaded) Rebalance(_ ClientID, view View) map[string]ClientID {
+	moves := make(map[string]ClientID)
+	if len(s.clients) == 0 {
+		return moves
+	}
+	cSum, wSum := 0, 0
+	for id, w := range s.clients {
+		cSum += view.Counter[id]
+		wSum += w
+	}
+
+	surplus := make(map[ClientID]int)
+	deficit := make([]ClientID, 0)
+	want := make(map[ClientID]int)
+	for id, w := range s.clients {
+		target := cSum * w / wSum
+		switch v := view.Counter[id]; {
+		case v > target:
+			surplus[id] = v - target
+		case v < target:
+			want[id] = target - v
+			deficit = append(deficit, id)
+		}
+	}
+	sort.Slice(deficit, func(i, j int) bool { return deficit[i] < deficit[j] })
+
+	for k, id := range view.Keys {
+		if len(deficit) == 0 {
+			break
+		}
+		if surplus[id] <= 0 {
+			continue
+		}
+		to := deficit[0]
+		moves[k] = to
+		surplus[id]--
+		if want[to]--; want[to] == 0 {
+			deficit = deficit[1:]
+		}
+	}
+
+	return moves
+}
+
+// roundRobin - new keys are handed out to clients in turns (smooth weighted round-robin),
+// rebalance moves nothing, joining client or new weight only affects new keys
+type roundRobin struct {
+	clients []*rrClient
+}
+
+type rrClient struct {
+	id      ClientID
+	weight  int
+	current int
+}
+
+func newRoundRobin() *roundRobin {
+	return &roundRobin{clients: make([]*rrClient, 0)}
+}
+
+func (s *roundRobin) Name() string {
+	return StrategyRoundRobin
+}
+
+func (s *roundRobin) AddClient(id ClientID, _ string, weight int) {
+	s.clients = append(s.clients, &rrClient{id: id, weight: weight})
+	sort.Slice(s.clients, func(i, j int) bool { return s.clients[i].id < s.clients[j].id })
+}
+
+func (s *roundRobin) RemoveClient(id ClientID) {
+	for i := range s.clients {
+		if s.clients[i].id == id {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *roundRobin) Assign(_ string, _ View) ClientID {
+	var best *rrClient
+	total := 0
+	for _, c := range s.clients {
+		c.current += c.weight
+		total += c.weight
+		if best == nil || c.current > best.current {
+			best = c
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	best.current -= total
+	return best.id
+}
+
+func (s *roundRobin) Rebalance(_ ClientID, _ View) map[string]ClientID {
+	return map[string]ClientID{}
+}
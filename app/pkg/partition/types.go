@@ -1,5 +1,26 @@
 package partition
 
+// Partition - client partition, messages are published with RoutingKey and land in client's Queue
 type Partition struct {
-	RoutingKey string
+	Hostname   string `json:"hostname"`
+	RoutingKey string `json:"routingKey"`
+	// Queue - name of client queue, optional, empty when client did not declare it while binding
+	Queue string `json:"queue,omitempty"`
+	// Weight - declared capacity, client gets share of keys proportional to its weight
+	Weight int `json:"weight,omitempty"`
+	// Labels - client labels, e.g. zone=eu-1, affinity rules can target clients by label
+	Labels map[string]string `json:"labels,omitempty"`
+	// Epoch - incarnation of the client, every bind creates a new one with higher epoch
+	Epoch uint64 `json:"epoch,omitempty"`
+}
+
+// ClientID - identity of a client, derived from its full hostname so two clients never share it
+type ClientID string
+
+// weight - partitions persisted before weights were introduced have zero weight
+func (p Partition) weight() int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
 }
@@ -7,7 +7,9 @@ import (
 
 type AmqpOrchestrator interface {
 	CreateExchange(exchange, kind string) error
+	CreateQueue(queue string, args amqp.Table) error
 	GetChannel(d Direction) (*amqp.Channel, error)
+	InspectQueue(queue string) (amqp.Queue, error)
 }
 
 type amqpCtx struct {
@@ -63,6 +65,21 @@ func (ac *amqpCtx) CreateExchange(exchange, kind string) error {
 	return nil
 }
 
+// CreateQueue - creates durable queue through amqp
+func (ac *amqpCtx) CreateQueue(queue string, args amqp.Table) error {
+	ch, err := ac.connections[DirectionPrimary].Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err = ch.QueueDeclare(queue, true, false, false, false, args); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type Partition struct {
 	Queue      string
 	RoutingKey string
@@ -78,6 +95,18 @@ func (ac *amqpCtx) GetChannel(d Direction) (*amqp.Channel, error) {
 	return ch, nil
 }
 
+// InspectQueue - passively declares queue and returns its state, fails if queue does not exist
+func (ac *amqpCtx) InspectQueue(queue string) (amqp.Queue, error) {
+	// failed passive declare closes the channel, so every inspection gets its own one
+	ch, err := ac.connections[DirectionPrimary].Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+
+	return ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+}
+
 func (ac *amqpCtx) handleConnectionClose(c <-chan *amqp.Error) {
 	for {
 		err := <-c
@@ -2,6 +2,11 @@ package rabbit
 
 const (
 	PartyMqExchange string = "partymq.ex.write"
+	OverflowQueue   string = "partymq.q.overflow"
+	DeadLetterQueue string = "partymq.q.dead-letter"
+
+	// EpochHeader - header with incarnation epoch of the client message was forwarded to
+	EpochHeader string = "x-partymq-epoch"
 )
 
 // Direction - type for amqp connection - PUB/SUB/PRIMARY
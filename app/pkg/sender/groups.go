@@ -0,0 +1,62 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Route - message whose Header has Value is partitioned among clients of Group
+type Route struct {
+	Header string
+	Value  string
+	Group  string
+}
+
+// ParseRoutes - parses routes in "header:value=group" format, e.g. "x-tenant-tier:premium=premium".
+// Clients join groups by label, so routes are rejected when no label defines client groups
+func ParseRoutes(routes []string, label string) ([]Route, error) {
+	parsed := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		if r == "" {
+			continue
+		}
+		match, group, ok := strings.Cut(r, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("route %q has to be in header:value=group format", r)
+		}
+		header, value, ok := strings.Cut(match, ":")
+		if !ok || header == "" {
+			return nil, fmt.Errorf("route %q has to be in header:value=group format", r)
+		}
+		if label == "" {
+			return nil, fmt.Errorf("route %q points to group %s no client can join, group label is not set", r, group)
+		}
+		parsed = append(parsed, Route{Header: header, Value: value, Group: group})
+	}
+	return parsed, nil
+}
+
+// group - first matching route decides client group, messages matched by no route go to the default group
+func (srv *srvContext) group(headers amqp.Table) string {
+	for _, r := range srv.routes {
+		v, ok := headers[r.Header]
+		if !ok {
+			continue
+		}
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case []byte:
+			s = string(t)
+		default:
+			s = fmt.Sprint(t)
+		}
+		if s == r.Value {
+			return r.Group
+		}
+	}
+	return ""
+}
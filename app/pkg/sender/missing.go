@@ -0,0 +1,77 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+
+	"github.com/dnsx2k/partymq/app/pkg/helpers"
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"github.com/dnsx2k/partymq/app/pkg/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// Missing key policies, applied on message without partition key
+const (
+	MissingRandom        = "random"
+	MissingDefault       = "default"
+	MissingHashBody      = "hash-body"
+	MissingHashMessageID = "hash-message-id"
+	MissingDeadLetter    = "dead-letter"
+	MissingDrop          = "drop"
+)
+
+// missingKeys - number of messages without key by applied policy, published at /debug/vars
+var missingKeys = expvar.NewMap("partymq_missing_keys")
+
+// missingPolicy - validates policy, default policy needs hostname of the client receiving messages without key
+func missingPolicy(policy, defaultClient string) error {
+	switch policy {
+	case MissingRandom, MissingHashBody, MissingHashMessageID, MissingDeadLetter, MissingDrop:
+	case MissingDefault:
+		if defaultClient == "" {
+			return fmt.Errorf("missing key policy %s requires default client", policy)
+		}
+	default:
+		return fmt.Errorf("unknown missing key policy: %s", policy)
+	}
+	return nil
+}
+
+// keyless - applies missing key policy, returns client the message goes to or false when it was already handled
+func (srv *srvContext) keyless(ctx context.Context, msg *amqp.Delivery, group string) (partition.Partition, bool, error) {
+	missingKeys.Add(srv.onMissing, 1)
+	switch srv.onMissing {
+	case MissingDefault:
+		owner, err := srv.cache.GetClient(srv.defaultClient)
+		// default client is not bound, message is parked in overflow queue and retried after park TTL
+		if errors.Is(err, partition.ErrClientNotFound) {
+			return partition.Partition{}, false, srv.delay(ctx, msg, withoutParking(msg.Headers))
+		}
+		return owner, true, err
+	case MissingHashBody:
+		owner, err := srv.cache.GetHashedPartition(group, string(msg.Body))
+		return owner, true, err
+	case MissingHashMessageID:
+		// messages without id are spread by their content
+		seed := msg.MessageId
+		if seed == "" {
+			seed = string(msg.Body)
+		}
+		owner, err := srv.cache.GetHashedPartition(group, seed)
+		return owner, true, err
+	case MissingDeadLetter:
+		srv.logger.Warn("message without partition key dead-lettered", zap.String("policy", srv.onMissing), zap.String("message_id", msg.MessageId), zap.String("app_id", msg.AppId))
+		pub := helpers.WrapAmqpDelivery(msg)
+		pub.Headers = withHeader(msg.Headers, ReasonHeader, "missing-key")
+		return partition.Partition{}, false, srv.publishChan.PublishWithContext(ctx, "", rabbit.DeadLetterQueue, false, false, pub)
+	case MissingDrop:
+		srv.logger.Warn("message without partition key dropped", zap.String("policy", srv.onMissing), zap.String("message_id", msg.MessageId), zap.String("app_id", msg.AppId))
+		return partition.Partition{}, false, nil
+	default:
+		owner, err := srv.cache.GetRoutingKey(group, "")
+		return owner, true, err
+	}
+}
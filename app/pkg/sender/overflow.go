@@ -0,0 +1,138 @@
+package sender
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dnsx2k/partymq/app/pkg/helpers"
+	"github.com/dnsx2k/partymq/app/pkg/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Overflow policies, applied when every client reached its key quota
+const (
+	OverflowAssign = "assign"
+	OverflowPark   = "park"
+	OverflowReject = "reject"
+)
+
+const (
+	// ParkedHeader - sequence number of message parked in overflow queue
+	ParkedHeader = "x-partymq-parked"
+	// ReasonHeader - reason why message was dead-lettered by PartyMQ
+	ReasonHeader = "x-partymq-reason"
+
+	deathHeader = "x-death"
+)
+
+// parkedKey - overflow queue is FIFO with constant TTL, so parked messages of a key return
+// to the source queue in order of their sequence numbers
+type parkedKey struct {
+	outstanding int
+	seq         int64
+	// reparkUpTo - when the oldest message fails again, every message parked before its re-park
+	// has to be re-parked as well, otherwise it would overtake the oldest one
+	reparkUpTo int64
+}
+
+type overflow struct {
+	keys  map[string]*parkedKey
+	mutex sync.Mutex
+}
+
+func newOverflow() *overflow {
+	return &overflow{keys: make(map[string]*parkedKey)}
+}
+
+// holds - reports whether message has to be parked regardless of free capacity to keep key ordering
+func (o *overflow) holds(key string, returning bool, seq int64) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	pk, ok := o.keys[key]
+	if !ok {
+		return false
+	}
+	if returning {
+		return seq <= pk.reparkUpTo
+	}
+	return pk.outstanding > 0
+}
+
+// park - reserves sequence number for message going to overflow queue
+func (o *overflow) park(key string, returning bool, seq int64) int64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	pk, ok := o.keys[key]
+	if !ok {
+		pk = &parkedKey{}
+		o.keys[key] = pk
+	}
+	// returning message without state was parked before restart, it's counted from now on
+	if !returning || !ok {
+		pk.outstanding++
+	} else if seq > pk.reparkUpTo {
+		pk.reparkUpTo = pk.seq
+	}
+	pk.seq++
+	return pk.seq
+}
+
+// unpark - returning message was forwarded to a client
+func (o *overflow) unpark(key string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	pk, ok := o.keys[key]
+	if !ok {
+		return
+	}
+	if pk.outstanding--; pk.outstanding <= 0 {
+		delete(o.keys, key)
+	}
+}
+
+// parked - returns sequence number of message returning from overflow queue
+func parked(headers amqp.Table) (int64, bool) {
+	v, ok := headers[ParkedHeader]
+	if !ok {
+		return 0, false
+	}
+	seq, _ := v.(int64)
+	return seq, true
+}
+
+// withoutParking - drops headers added while message was parked
+func withoutParking(headers amqp.Table) amqp.Table {
+	h := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		if k == ParkedHeader || k == deathHeader {
+			continue
+		}
+		h[k] = v
+	}
+	return h
+}
+
+// overflowed - applies overflow policy on message whose key could not be assigned because of quota
+func (srv *srvContext) overflowed(ctx context.Context, msg *amqp.Delivery, headers amqp.Table, key string, returning bool, seq int64) error {
+	if srv.overflowPolicy == OverflowPark {
+		return srv.hold(ctx, msg, headers, key, returning, seq)
+	}
+	pub := helpers.WrapAmqpDelivery(msg)
+	pub.Headers = withHeader(headers, ReasonHeader, "quota-exceeded")
+	return srv.publishChan.PublishWithContext(ctx, "", rabbit.DeadLetterQueue, false, false, pub)
+}
+
+// hold - parks message of a key in overflow queue, later messages of the key are parked behind it
+func (srv *srvContext) hold(ctx context.Context, msg *amqp.Delivery, headers amqp.Table, key string, returning bool, seq int64) error {
+	pub := helpers.WrapAmqpDelivery(msg)
+	next := srv.overflow.park(key, returning, seq)
+	pub.Headers = withHeader(withoutParking(headers), ParkedHeader, next)
+	return srv.publishChan.PublishWithContext(ctx, "", rabbit.OverflowQueue, false, false, pub)
+}
+
+// delay - parks message without key in overflow queue, there is no key ordering to keep
+func (srv *srvContext) delay(ctx context.Context, msg *amqp.Delivery, headers amqp.Table) error {
+	pub := helpers.WrapAmqpDelivery(msg)
+	pub.Headers = headers
+	return srv.publishChan.PublishWithContext(ctx, "", rabbit.OverflowQueue, false, false, pub)
+}
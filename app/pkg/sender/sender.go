@@ -2,8 +2,13 @@ package sender
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/dnsx2k/partymq/app/pkg/handoff"
 	"github.com/dnsx2k/partymq/app/pkg/helpers"
+	"github.com/dnsx2k/partymq/app/pkg/hotkey"
+	"github.com/dnsx2k/partymq/app/pkg/load"
 	"github.com/dnsx2k/partymq/app/pkg/partition"
 	"github.com/dnsx2k/partymq/app/pkg/rabbit"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -11,44 +16,164 @@ import (
 )
 
 type srvContext struct {
-	cache       partition.Cache
-	publishChan *amqp.Channel
-	logger      *zap.Logger
+	cache          partition.Cache
+	handoff        handoff.Handoff
+	publishChan    *amqp.Channel
+	logger         *zap.Logger
+	overflowPolicy string
+	overflow       *overflow
+	routes         []Route
+	hotKeys        hotkey.Tracker
+	meter          load.Meter
+	onMissing      string
+	defaultClient  string
 }
 
+// SlotHeader - header with slot number of the message partition key, set in slot mode only
+const SlotHeader = "x-partymq-slot"
+
 type Sender interface {
-	Send(ctx context.Context, msg []byte, headers amqp.Table, key string) error
+	Send(ctx context.Context, msg *amqp.Delivery, key string) error
 	Ready() bool
 }
 
-// New - creation function for PartyOrchestrator
-func New(cache partition.Cache, pubCh *amqp.Channel, logger *zap.Logger) (Sender, error) {
+// New - creation function for PartyOrchestrator, overflow policy is applied when cache rejects key over quota,
+// routes pick client group of the message, traffic of every key is counted by hot key tracker and of every partition by meter.
+// onMissing policy is applied on messages without key, defaultClient is hostname of the client used by default policy
+func New(cache partition.Cache, handoff handoff.Handoff, pubCh *amqp.Channel, logger *zap.Logger, overflowPolicy string, routes []Route, hotKeys hotkey.Tracker, meter load.Meter, onMissing, defaultClient string) (Sender, error) {
+	switch overflowPolicy {
+	case OverflowAssign, OverflowPark, OverflowReject:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy: %s", overflowPolicy)
+	}
+	if err := missingPolicy(onMissing, defaultClient); err != nil {
+		return nil, err
+	}
 	return &srvContext{
-		publishChan: pubCh,
-		cache:       cache,
-		logger:      logger,
+		publishChan:    pubCh,
+		cache:          cache,
+		handoff:        handoff,
+		logger:         logger,
+		overflowPolicy: overflowPolicy,
+		overflow:       newOverflow(),
+		routes:         routes,
+		hotKeys:        hotKeys,
+		meter:          meter,
+		onMissing:      onMissing,
+		defaultClient:  defaultClient,
 	}, nil
 }
 
+// Ready - messages can be consumed once any client is bound and messages parked by previous run were forwarded
 func (srv *srvContext) Ready() bool {
-	return srv.cache.AnyClients()
+	return srv.cache.AnyClients() && srv.handoff.Recovered()
 }
 
-// Send - sends message on partition based on passed key
-func (srv *srvContext) Send(ctx context.Context, msg []byte, headers amqp.Table, key string) error {
-	routingKey, err := srv.cache.GetRoutingKey(key)
+// Send - sends message on partition based on passed key, within client group picked by message headers.
+// Message without key is handled by missing key policy. Message of a group without clients is parked
+// in overflow queue until a client of the group binds.
+func (srv *srvContext) Send(ctx context.Context, delivery *amqp.Delivery, key string) error {
+	headers := delivery.Headers
+	group := srv.group(headers)
+	if key == "" {
+		owner, ok, err := srv.keyless(ctx, delivery, group)
+		if errors.Is(err, partition.ErrClientNotFound) {
+			return srv.delay(ctx, delivery, withoutParking(headers))
+		}
+		if err != nil || !ok {
+			return err
+		}
+		return srv.publish(ctx, delivery, headers, owner)
+	}
+	assignmentKey := key
+	if slot, ok := srv.cache.Slot(key); ok {
+		headers = withHeader(headers, SlotHeader, int32(slot))
+		assignmentKey = partition.SlotKey(slot)
+	}
+	groupKey := partition.GroupKey(group, key)
+	srv.hotKeys.Observe(partition.GroupKey(group, assignmentKey))
+	seq, returning := parked(headers)
+	if returning {
+		headers = withoutParking(headers)
+	}
+	parking, fenced, err := srv.handoff.Park(partition.GroupKey(group, assignmentKey))
+	if err != nil {
+		return err
+	}
+	if fenced {
+		if err = srv.park(ctx, delivery, headers, group, key, parking); err != nil {
+			return err
+		}
+		// handoff keeps the order from now on, message left overflow queue
+		if returning {
+			srv.overflow.unpark(groupKey)
+		}
+		return nil
+	}
+	// earlier messages of the key are parked, regardless of why, so this one has to wait behind them
+	if srv.overflow.holds(groupKey, returning, seq) {
+		return srv.hold(ctx, delivery, headers, groupKey, returning, seq)
+	}
+
+	owner, err := srv.cache.GetRoutingKey(group, key)
+	if errors.Is(err, partition.ErrClientNotFound) {
+		return srv.hold(ctx, delivery, headers, groupKey, returning, seq)
+	}
 	if err != nil {
 		return err
 	}
-	if routingKey == "" {
-		routingKey = srv.cache.AssignToFreePartition(key)
+	if owner.RoutingKey == "" {
+		owner, err = srv.cache.AssignToFreePartition(group, key)
+		if errors.Is(err, partition.ErrQuotaExceeded) {
+			return srv.overflowed(ctx, delivery, headers, groupKey, returning, seq)
+		}
+		if errors.Is(err, partition.ErrClientNotFound) {
+			return srv.hold(ctx, delivery, headers, groupKey, returning, seq)
+		}
+		if err != nil {
+			return err
+		}
 	}
+	if returning {
+		srv.overflow.unpark(groupKey)
+	}
+
+	return srv.publish(ctx, delivery, headers, owner)
+}
 
-	pub := helpers.WrapAmqpPublishing(msg)
-	pub.Headers = headers
-	if err := srv.publishChan.PublishWithContext(ctx, rabbit.PartyMqExchange, routingKey, false, false, pub); err != nil {
+// publish - forwards message to its owner's partition
+func (srv *srvContext) publish(ctx context.Context, msg *amqp.Delivery, headers amqp.Table, owner partition.Partition) error {
+	pub := helpers.WrapAmqpDelivery(msg)
+	pub.Headers = withHeader(headers, rabbit.EpochHeader, int64(owner.Epoch))
+	if err := srv.publishChan.PublishWithContext(ctx, rabbit.PartyMqExchange, owner.RoutingKey, false, false, pub); err != nil {
 		return err
 	}
+	srv.meter.Forwarded(owner.RoutingKey)
 
 	return nil
 }
+
+// park - publishes message of key which is being handed off to its parking queue
+func (srv *srvContext) park(ctx context.Context, msg *amqp.Delivery, headers amqp.Table, group, key string, parking handoff.Parking) error {
+	pub := helpers.WrapAmqpDelivery(msg)
+	pub.Headers = withHeader(headers, handoff.KeyHeader, key)
+	if group != "" {
+		pub.Headers[handoff.GroupHeader] = group
+	}
+	if err := srv.publishChan.PublishWithContext(ctx, "", parking.Queue, false, false, pub); err != nil {
+		parking.Cancel()
+		return err
+	}
+
+	return nil
+}
+
+// withHeader - returns copy of headers with additional header, consumed delivery headers stay untouched
+func withHeader(headers amqp.Table, name string, value any) amqp.Table {
+	h := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[name] = value
+	return h
+}
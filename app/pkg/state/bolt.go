@@ -0,0 +1,269 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketClients = []byte("clients")
+	bucketPending = []byte("pending")
+	bucketKeys    = []byte("keys")
+	bucketAbsent  = []byte("suspended")
+	bucketExpiry  = []byte("expiry")
+	bucketRules   = []byte("rules")
+	bucketMeta    = []byte("meta")
+)
+
+var buckets = [][]byte{bucketClients, bucketPending, bucketKeys, bucketAbsent, bucketExpiry, bucketRules, bucketMeta}
+
+// rulesKey - rules are kept under single key, their order matters
+var rulesKey = []byte("rules")
+
+// epochKey - highest incarnation epoch ever issued
+var epochKey = []byte("epoch")
+
+// boltStore - keeps state in bbolt buckets, mutations are applied in place so there is no log to replay
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (bs *boltStore) Load() (State, error) {
+	s := NewState()
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if err := loadPartitions(tx.Bucket(bucketClients), s.Partition.Clients); err != nil {
+			return err
+		}
+		if err := loadPartitions(tx.Bucket(bucketPending), s.Partition.Pending); err != nil {
+			return err
+		}
+		if v := tx.Bucket(bucketRules).Get(rulesKey); v != nil {
+			if err := json.Unmarshal(v, &s.Partition.Rules); err != nil {
+				return err
+			}
+		}
+		s.Partition.Epoch = loadEpoch(tx)
+		if err := tx.Bucket(bucketKeys).ForEach(func(k, v []byte) error {
+			s.Partition.Keys[string(k)] = string(v)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketAbsent).ForEach(func(k, _ []byte) error {
+			s.Partition.Suspended[string(k)] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketExpiry).ForEach(func(k, v []byte) error {
+			var t time.Time
+			if err := t.UnmarshalBinary(v); err != nil {
+				return err
+			}
+			s.Expiry[string(k)] = t
+			return nil
+		})
+	})
+
+	return s, err
+}
+
+func (bs *boltStore) Append(events []partition.Event) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for i := range events {
+			if err := applyEvent(tx, events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *boltStore) Save(s State) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if err := tx.DeleteBucket(b); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(b); err != nil {
+				return err
+			}
+		}
+		for hostname, p := range s.Partition.Clients {
+			if err := putPartition(tx.Bucket(bucketClients), hostname, p); err != nil {
+				return err
+			}
+		}
+		for hostname, p := range s.Partition.Pending {
+			if err := putPartition(tx.Bucket(bucketPending), hostname, p); err != nil {
+				return err
+			}
+		}
+		keys := tx.Bucket(bucketKeys)
+		for k, hostname := range s.Partition.Keys {
+			if err := keys.Put([]byte(k), []byte(hostname)); err != nil {
+				return err
+			}
+		}
+		absent := tx.Bucket(bucketAbsent)
+		for hostname := range s.Partition.Suspended {
+			if err := absent.Put([]byte(hostname), nil); err != nil {
+				return err
+			}
+		}
+		if err := putRules(tx, s.Partition.Rules); err != nil {
+			return err
+		}
+		if err := putEpoch(tx, s.Partition.Epoch); err != nil {
+			return err
+		}
+		expiry := tx.Bucket(bucketExpiry)
+		for hostname, t := range s.Expiry {
+			b, err := t.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err = expiry.Put([]byte(hostname), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *boltStore) Close() error {
+	return bs.db.Close()
+}
+
+func applyEvent(tx *bolt.Tx, e partition.Event) error {
+	hostname := []byte(e.Hostname)
+	switch e.Type {
+	case partition.EventPending:
+		if e.Partition.Epoch > loadEpoch(tx) {
+			if err := putEpoch(tx, e.Partition.Epoch); err != nil {
+				return err
+			}
+		}
+		return putPartition(tx.Bucket(bucketPending), e.Hostname, e.Partition)
+	case partition.EventReady:
+		if err := tx.Bucket(bucketPending).Delete(hostname); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketAbsent).Delete(hostname); err != nil {
+			return err
+		}
+		return putPartition(tx.Bucket(bucketClients), e.Hostname, e.Partition)
+	case partition.EventUpdate:
+		return putPartition(tx.Bucket(bucketClients), e.Hostname, e.Partition)
+	case partition.EventDelete:
+		if err := tx.Bucket(bucketClients).Delete(hostname); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketPending).Delete(hostname); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketAbsent).Delete(hostname); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketExpiry).Delete(hostname); err != nil {
+			return err
+		}
+		c := tx.Bucket(bucketKeys).Cursor()
+		for k, v := c.First(); k != nil; {
+			if string(v) != e.Hostname {
+				k, v = c.Next()
+				continue
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			// cursor points to the next item after delete
+			k, v = c.Seek(k)
+		}
+		return nil
+	case partition.EventSuspend:
+		return tx.Bucket(bucketAbsent).Put(hostname, nil)
+	case partition.EventResume:
+		return tx.Bucket(bucketAbsent).Delete(hostname)
+	case partition.EventAssign:
+		return tx.Bucket(bucketKeys).Put([]byte(e.Key), hostname)
+	case partition.EventUnassign:
+		return tx.Bucket(bucketKeys).Delete([]byte(e.Key))
+	case partition.EventRule, partition.EventRuleDelete:
+		// rules are few, so they are replayed on a throwaway snapshot and written back as a whole
+		s := partition.NewSnapshot()
+		if v := tx.Bucket(bucketRules).Get(rulesKey); v != nil {
+			if err := json.Unmarshal(v, &s.Rules); err != nil {
+				return err
+			}
+		}
+		s.Apply(e)
+		return putRules(tx, s.Rules)
+	}
+
+	return nil
+}
+
+func putRules(tx *bolt.Tx, rules []partition.Rule) error {
+	v, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketRules).Put(rulesKey, v)
+}
+
+func putEpoch(tx *bolt.Tx, epoch uint64) error {
+	return tx.Bucket(bucketMeta).Put(epochKey, binary.BigEndian.AppendUint64(nil, epoch))
+}
+
+func loadEpoch(tx *bolt.Tx) uint64 {
+	v := tx.Bucket(bucketMeta).Get(epochKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func putPartition(b *bolt.Bucket, hostname string, p partition.Partition) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(hostname), v)
+}
+
+func loadPartitions(b *bolt.Bucket, dst map[string]partition.Partition) error {
+	return b.ForEach(func(k, v []byte) error {
+		var p partition.Partition
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+		dst[string(k)] = p
+		return nil
+	})
+}
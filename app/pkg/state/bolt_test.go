@@ -0,0 +1,37 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+func TestBoltDeleteKeys(t *testing.T) {
+	// deleted client owns the first and the last key and runs of adjacent keys, cursor must not skip any of them
+	owners := []string{"a", "a", "b", "a", "a", "a", "b", "b", "a", "b", "a"}
+	for _, deleted := range []string{"a", "b", "c"} {
+		t.Run(deleted, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, BackendBolt, dir)
+			want := NewState()
+			batch := make([]partition.Event, 0)
+			for i, owner := range owners {
+				key := fmt.Sprintf("k%02d", i)
+				batch = append(batch, partition.Event{Type: partition.EventAssign, Hostname: owner, Key: key})
+				if owner != deleted {
+					want.Partition.Keys[key] = owner
+				}
+			}
+			batch = append(batch, partition.Event{Type: partition.EventDelete, Hostname: deleted})
+			if err := s.Append(batch); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reopen(t, s, BackendBolt, dir).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkState(t, got, want)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+// fileStore - keeps state as json snapshot and write ahead log of cache mutations, one json event per line
+type fileStore struct {
+	dir string
+	wal *os.File
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileStore{dir: dir, wal: wal}, nil
+}
+
+func (fs *fileStore) Load() (State, error) {
+	s := NewState()
+	b, err := os.ReadFile(filepath.Join(fs.dir, snapshotFile))
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(b, &s); err != nil {
+			return s, err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return s, err
+	}
+	if s.Expiry == nil {
+		s.Expiry = make(map[string]time.Time)
+	}
+
+	f, err := os.Open(filepath.Join(fs.dir, walFile))
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// last line without new line is a torn write, it was never acknowledged
+			return s, nil
+		}
+		if err != nil {
+			return s, err
+		}
+		var e partition.Event
+		if err = json.Unmarshal(line, &e); err != nil {
+			return s, err
+		}
+		s.apply(e)
+	}
+}
+
+func (fs *fileStore) Append(events []partition.Event) error {
+	buf := make([]byte, 0, 128*len(events))
+	for i := range events {
+		b, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+	if _, err := fs.wal.Write(buf); err != nil {
+		return err
+	}
+	// journal appends in batches, so a batch costs one sync
+	return fs.wal.Sync()
+}
+
+func (fs *fileStore) Save(s State) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(filepath.Join(fs.dir, snapshotFile), b); err != nil {
+		return err
+	}
+
+	return fs.wal.Truncate(0)
+}
+
+// writeFile - replaces file atomically, reader sees either old or new content
+func writeFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fs *fileStore) Close() error {
+	return fs.wal.Close()
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+func TestFileWALReplayOrder(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, BackendFile, dir)
+	for _, batch := range [][]partition.Event{
+		{{Type: partition.EventAssign, Hostname: "a", Key: "k1"}, {Type: partition.EventAssign, Hostname: "b", Key: "k1", From: "a"}},
+		{{Type: partition.EventAssign, Hostname: "a", Key: "k2"}, {Type: partition.EventUnassign, Hostname: "a", Key: "k2"}},
+		{{Type: partition.EventUnassign, Hostname: "b", Key: "k1"}},
+		{{Type: partition.EventAssign, Hostname: "a", Key: "k1"}, {Type: partition.EventAssign, Hostname: "a", Key: "k2"}},
+		{{Type: partition.EventAssign, Hostname: "c", Key: "k2", From: "a"}},
+	} {
+		if err := s.Append(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := reopen(t, s, BackendFile, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewState()
+	want.Partition.Keys = map[string]string{"k1": "a", "k2": "c"}
+	checkState(t, got, want)
+}
+
+func TestFileTornLastLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    string
+		wantErr bool
+	}{
+		{name: "torn event", tail: `{"type":"assign","hostname":"b","ke`},
+		{name: "torn event without body", tail: `{`},
+		{name: "corrupted event", tail: "{\"type\":\"assign\",\"hostname\":\"b\"\n", wantErr: true},
+		{name: "torn event after corrupted one", tail: "not json\n{\"type\":", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, BackendFile, dir)
+			if err := s.Append([]partition.Event{{Type: partition.EventAssign, Hostname: "a", Key: "k1"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = f.WriteString(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+			_ = f.Close()
+
+			s = openStore(t, BackendFile, dir)
+			defer s.Close()
+			got, err := s.Load()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("corrupted log was loaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := NewState()
+			want.Partition.Keys["k1"] = "a"
+			checkState(t, got, want)
+		})
+	}
+}
+
+func TestFileSaveReplacesWAL(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, BackendFile, dir)
+	if err := s.Append(events()); err != nil {
+		t.Fatal(err)
+	}
+	saved := NewState()
+	saved.Partition.Clients["b"] = client("b", 2)
+	saved.Partition.Keys["k1"] = "b"
+	if err := s.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, walFile)); err != nil || info.Size() != 0 {
+		t.Fatalf("log is not empty after save: %v, %v", info.Size(), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary snapshot left behind: %v", err)
+	}
+
+	// events appended after save go to the start of the truncated log
+	if err := s.Append([]partition.Event{{Type: partition.EventAssign, Hostname: "b", Key: "k2"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopen(t, s, BackendFile, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.Partition.Keys["k2"] = "b"
+	checkState(t, got, saved)
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"go.uber.org/zap"
+)
+
+const (
+	journalBuffer = 4096
+	maxBatch      = 512
+)
+
+type job struct {
+	event partition.Event
+	state *State
+}
+
+// Journal - writes cache mutations to the store in background, keeps their order
+type Journal struct {
+	store  Store
+	logger *zap.Logger
+	jobs   chan job
+}
+
+// NewJournal - creates journal and starts its writer
+func NewJournal(store Store, logger *zap.Logger) *Journal {
+	j := &Journal{
+		store:  store,
+		logger: logger,
+		jobs:   make(chan job, journalBuffer),
+	}
+	go j.write()
+
+	return j
+}
+
+// Record - queues cache mutation, can be subscribed to the cache as partition.Listener
+func (j *Journal) Record(e partition.Event) {
+	j.jobs <- job{event: e}
+}
+
+// Save - queues full state snapshot, mutations queued before are written first
+func (j *Journal) Save(s State) {
+	j.jobs <- job{state: &s}
+}
+
+// StartSnapshots - periodically calls capture which has to Save state, so replayed mutation log stays short.
+// State has to be taken and saved while no mutation can be recorded, otherwise mutations recorded in between
+// would be written before the snapshot and wiped by it
+func (j *Journal) StartSnapshots(interval time.Duration, capture func()) {
+	go func() {
+		for {
+			<-time.After(interval)
+			capture()
+		}
+	}()
+}
+
+func (j *Journal) write() {
+	batch := make([]partition.Event, 0, maxBatch)
+	for jb := range j.jobs {
+		if jb.state != nil {
+			j.save(*jb.state)
+			continue
+		}
+		batch = append(batch[:0], jb.event)
+		var pending *State
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case next := <-j.jobs:
+				if next.state != nil {
+					pending = next.state
+					break collect
+				}
+				batch = append(batch, next.event)
+			default:
+				break collect
+			}
+		}
+		if err := j.store.Append(batch); err != nil {
+			j.logger.Error("can not persist cache mutations", zap.Int("count", len(batch)), zap.Error(err))
+		}
+		if pending != nil {
+			j.save(*pending)
+		}
+	}
+}
+
+func (j *Journal) save(s State) {
+	if err := j.store.Save(s); err != nil {
+		j.logger.Error("can not persist state snapshot", zap.Error(err))
+	}
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+	"go.uber.org/zap"
+)
+
+// RuleFile - keeps affinity rules in a json file, so they survive restart without state backend
+type RuleFile struct {
+	path   string
+	rules  partition.Snapshot
+	logger *zap.Logger
+}
+
+// OpenRuleFile - loads rules persisted in file at path, missing file means no rules
+func OpenRuleFile(path string, logger *zap.Logger) (*RuleFile, error) {
+	rf := &RuleFile{path: path, rules: partition.NewSnapshot(), logger: logger}
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(b, &rf.rules.Rules); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Rules - returns loaded rules in their order
+func (rf *RuleFile) Rules() []partition.Rule {
+	return rf.rules.Rules
+}
+
+// Record - partition.Listener, rewrites the file on every rule change. Rules are few and change rarely,
+// so the file is written right away under the cache lock
+func (rf *RuleFile) Record(e partition.Event) {
+	if e.Type != partition.EventRule && e.Type != partition.EventRuleDelete {
+		return
+	}
+	rf.rules.Apply(e)
+	b, err := json.Marshal(rf.rules.Rules)
+	if err == nil {
+		err = writeFile(rf.path, b)
+	}
+	if err != nil {
+		rf.logger.Error("can not persist affinity rules", zap.String("path", rf.path), zap.Error(err))
+	}
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+// Backend names, used in config
+const (
+	BackendNone = "none"
+	BackendFile = "file"
+	BackendBolt = "bolt"
+)
+
+// State - persisted PartyMQ state: clients, pending bindings, key assignments and heartbeat expiry
+type State struct {
+	Partition partition.Snapshot   `json:"partition"`
+	Expiry    map[string]time.Time `json:"expiry"`
+}
+
+// NewState - creates empty state
+func NewState() State {
+	return State{
+		Partition: partition.NewSnapshot(),
+		Expiry:    make(map[string]time.Time),
+	}
+}
+
+// Store - persists PartyMQ state as snapshot followed by cache mutations
+type Store interface {
+	// Load - returns persisted state, empty state if nothing was persisted yet
+	Load() (State, error)
+	// Append - persists cache mutations made after the last snapshot
+	Append(events []partition.Event) error
+	// Save - persists full state, mutations appended so far are dropped
+	Save(s State) error
+	Close() error
+}
+
+// New - creates store for passed backend, path points to a directory for file backend and to a database file for bolt
+func New(backend, path string) (Store, error) {
+	switch backend {
+	case BackendFile:
+		return newFileStore(path)
+	case BackendBolt:
+		return newBoltStore(path)
+	}
+
+	return nil, fmt.Errorf("unknown state backend: %s", backend)
+}
+
+// apply - replays cache mutation on the state, heartbeat expiry is only persisted in snapshots
+func (s *State) apply(e partition.Event) {
+	s.Partition.Apply(e)
+	if e.Type == partition.EventDelete {
+		delete(s.Expiry, e.Hostname)
+	}
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dnsx2k/partymq/app/pkg/partition"
+)
+
+var backends = []string{BackendFile, BackendBolt}
+
+// openStore - opens store of backend in dir, reopening the same dir gives the persisted state back
+func openStore(t *testing.T, backend, dir string) Store {
+	t.Helper()
+	path := dir
+	if backend == BackendBolt {
+		path = filepath.Join(dir, "state.db")
+	}
+	s, err := New(backend, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func reopen(t *testing.T, s Store, backend, dir string) Store {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openStore(t, backend, dir)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func client(hostname string, epoch uint64) partition.Partition {
+	return partition.Partition{Hostname: hostname, RoutingKey: "rk-" + hostname, Queue: "q-" + hostname, Weight: 1, Epoch: epoch}
+}
+
+// events - every kind of cache mutation, a is deleted at the end so its keys and expiry have to disappear
+func events() []partition.Event {
+	heavy := client("b", 2)
+	heavy.Weight = 3
+	heavy.Labels = map[string]string{"zone": "eu"}
+	return []partition.Event{
+		{Type: partition.EventPending, Hostname: "a", Partition: client("a", 1)},
+		{Type: partition.EventReady, Hostname: "a", Partition: client("a", 1)},
+		{Type: partition.EventPending, Hostname: "b", Partition: client("b", 2)},
+		{Type: partition.EventReady, Hostname: "b", Partition: client("b", 2)},
+		{Type: partition.EventPending, Hostname: "c", Partition: client("c", 3)},
+		{Type: partition.EventAssign, Hostname: "a", Key: "k1"},
+		{Type: partition.EventAssign, Hostname: "a", Key: "k2"},
+		{Type: partition.EventAssign, Hostname: "b", Key: "k3"},
+		{Type: partition.EventAssign, Hostname: "a", Key: "k4"},
+		{Type: partition.EventAssign, Hostname: "b", Key: "group|k1"},
+		{Type: partition.EventUpdate, Hostname: "b", Partition: heavy},
+		{Type: partition.EventSuspend, Hostname: "a"},
+		{Type: partition.EventResume, Hostname: "a"},
+		{Type: partition.EventSuspend, Hostname: "b"},
+		{Type: partition.EventRule, Rule: &partition.Rule{ID: "r1", Match: partition.MatchPrefix, Pattern: "k", Hostname: "a"}},
+		{Type: partition.EventRule, Rule: &partition.Rule{ID: "r2", Match: partition.MatchExact, Pattern: "k3", Label: "zone=eu"}},
+		{Type: partition.EventRule, Rule: &partition.Rule{ID: "r1", Match: partition.MatchPrefix, Pattern: "k", Hostname: "b"}},
+		{Type: partition.EventRuleDelete, Rule: &partition.Rule{ID: "r2"}},
+		{Type: partition.EventUnassign, Hostname: "a", Key: "k2"},
+		{Type: partition.EventAssign, Hostname: "b", Key: "k4", From: "a"},
+		{Type: partition.EventDelete, Hostname: "a"},
+	}
+}
+
+// wantState - state after events on top of base, written out instead of replayed
+func wantState(base State) State {
+	s := NewState()
+	s.Partition.Clients["b"] = events()[10].Partition
+	s.Partition.Pending["c"] = client("c", 3)
+	s.Partition.Keys = map[string]string{"k3": "b", "k4": "b", "group|k1": "b"}
+	s.Partition.Suspended["b"] = true
+	s.Partition.Rules = []partition.Rule{{ID: "r1", Match: partition.MatchPrefix, Pattern: "k", Hostname: "b"}}
+	s.Partition.Epoch = 3
+	for hostname, t := range base.Expiry {
+		if hostname != "a" {
+			s.Expiry[hostname] = t
+		}
+	}
+	return s
+}
+
+func checkState(t *testing.T, got, want State) {
+	t.Helper()
+	if !reflect.DeepEqual(got.Partition, want.Partition) {
+		t.Errorf("loaded partitions %+v, want %+v", got.Partition, want.Partition)
+	}
+	if len(got.Expiry) != len(want.Expiry) {
+		t.Errorf("loaded expiry %v, want %v", got.Expiry, want.Expiry)
+	}
+	for hostname, e := range want.Expiry {
+		if !got.Expiry[hostname].Equal(e) {
+			t.Errorf("loaded expiry of %s %v, want %v", hostname, got.Expiry[hostname], e)
+		}
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	expiry := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	base := NewState()
+	base.Partition.Clients["a"] = client("a", 1)
+	base.Partition.Keys["k0"] = "a"
+	base.Expiry["a"] = expiry
+	base.Expiry["b"] = expiry.Add(time.Minute)
+
+	for _, backend := range backends {
+		t.Run(backend+"/empty", func(t *testing.T) {
+			dir := t.TempDir()
+			s := reopen(t, openStore(t, backend, dir), backend, dir)
+			got, err := s.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkState(t, got, NewState())
+		})
+		t.Run(backend+"/append", func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, backend, dir)
+			e := events()
+			// journal appends in batches
+			for _, batch := range [][]partition.Event{e[:7], e[7:8], e[8:]} {
+				if err := s.Append(batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := reopen(t, s, backend, dir).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkState(t, got, wantState(NewState()))
+		})
+		t.Run(backend+"/save and append", func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, backend, dir)
+			if err := s.Append(events()[:4]); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save(base); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Append(events()); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reopen(t, s, backend, dir).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// k0 was owned by a which is deleted
+			checkState(t, got, wantState(base))
+		})
+		t.Run(backend+"/save replaces state", func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, backend, dir)
+			if err := s.Append(events()); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save(base); err != nil {
+				t.Fatal(err)
+			}
+			got, err := reopen(t, s, backend, dir).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkState(t, got, base)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+//go:build wasip1
+
+// Key plugin example, go:wasmexport and c-shared build mode on wasip1 need Go 1.24 or newer toolchain,
+// although the module requires 1.22 only. Builds with:
+// GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o key.wasm ./examples/plugin
+// or with older installed Go: GOTOOLCHAIN=go1.24.0 GOOS=wasip1 GOARCH=wasm go build ...
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// buffers - allocated buffers are kept referenced until host frees them
+var buffers = map[uint32][]byte{}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	b := make([]byte, size+1)
+	ptr := uint32(uintptr(unsafe.Pointer(&b[0])))
+	buffers[ptr] = b
+	return ptr
+}
+
+//go:wasmexport free
+func free(ptr uint32) {
+	delete(buffers, ptr)
+}
+
+// partitionKey - key is tenant header joined with message id, messages without tenant have no key
+//
+//go:wasmexport partition_key
+func partitionKey(metaPtr, metaLen, bodyPtr, bodyLen uint32) uint64 {
+	var meta struct {
+		Headers    map[string]any    `json:"headers"`
+		Properties map[string]string `json:"properties"`
+	}
+	if err := json.Unmarshal(buffers[metaPtr][:metaLen], &meta); err != nil {
+		return 0
+	}
+	tenant, _ := meta.Headers["tenant"].(string)
+	if tenant == "" {
+		return 0
+	}
+	key := tenant + ":" + meta.Properties["message-id"]
+	ptr := alloc(uint32(len(key)))
+	copy(buffers[ptr], key)
+	return uint64(ptr)<<32 | uint64(len(key))
+}
+
+func main() {}
@@ -62,7 +62,7 @@ func build(ID string, n int) amqp.Publishing {
 	msg := map[string]interface{}{
 		"id":          ID,
 		"num":         n,
-		"name":        fmt.Sprintf("%s+random:%v", "Neque porro quisquam est qui dolorem ipsum quia dolor sit amet, consectetur, adipisci velit"),
+		"name":        fmt.Sprintf("%s+random:%v", "Neque porro quisquam est qui dolorem ipsum quia dolor sit amet, consectetur, adipisci velit", n),
 		"description": "Lorem ipsum dolor sit amet, consectetur adipiscing elit. Mauris vehicula interdum neque et sollicitudin. Nunc tincidunt sem ut nisi efficitur, eu ullamcorper augue ullamcorper. Proin iaculis arcu lectus, sed faucibus tortor aliquet vel. Nulla egestas purus erat, quis rhoncus lorem dictum fringilla. Maecenas quis ultricies justo, ac malesuada ligula. Nunc a ligula eget nisi elementum sodales. Curabitur eleifend sit amet ex a fringilla. Proin consectetur eu eros id mollis. Ut sed facilisis tellus, vel imperdiet odio. In pretium dolor lectus, vel iaculis tortor tincidunt sit amet. Vivamus varius nisi eu. ",
 	}
 	body, _ := json.Marshal(msg)